@@ -0,0 +1,257 @@
+package kat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultBufferSize is the number of records buffered per container
+// when none is configured.
+const DefaultBufferSize = 1024
+
+// OverflowPolicy selects what happens to a record delivered while
+// its container's buffer is full.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // Wait for space, holding up the stream.
+	OverflowDropOldest                       // Drop the oldest buffered record to make space.
+	OverflowDropNewest                       // Drop the record delivered.
+)
+
+// String returns the flag value naming the policy.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	default:
+		return "block"
+	}
+}
+
+// ParseOverflowPolicy parses the name of an overflow policy, as
+// returned by OverflowPolicy.String.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch name {
+	case "block":
+		return OverflowBlock, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "drop-newest":
+		return OverflowDropNewest, nil
+	default:
+		return 0, fmt.Errorf("unknown overflow policy %q (expected block, drop-oldest or drop-newest)", name)
+	}
+}
+
+// BufferPolicy decouples reading the logs of each container from
+// writing them, so that a slow terminal or a stalled disk does not
+// hold up reading from the kubelet for as long as the buffer has
+// space. Records dropped under the overflow policy are replaced in
+// the output by a marker record saying how many were lost.
+type BufferPolicy struct {
+	Size     int // Records buffered per container; zero or negative selects DefaultBufferSize.
+	Overflow OverflowPolicy
+}
+
+func (p *BufferPolicy) size() int {
+	if p.Size <= 0 {
+		return DefaultBufferSize
+	}
+
+	return p.Size
+}
+
+// droppedRecord returns the marker record standing in for count
+// records of a stream that were dropped.
+func droppedRecord(stream StreamInfo, count int) LogRecord {
+	return LogRecord{
+		StreamInfo: stream,
+		Received:   time.Now(),
+		Line:       "[" + countedLines(count) + " dropped]",
+		Dropped:    count,
+	}
+}
+
+// bufferedRecord is a record waiting in a buffer.
+type bufferedRecord struct {
+	record  LogRecord
+	dropped int // Records dropped immediately before this one.
+}
+
+// recordBuffer is a bounded queue of records between the goroutine
+// streaming a container and the goroutine writing its records.
+type recordBuffer struct {
+	size     int
+	overflow OverflowPolicy
+
+	mu        sync.Mutex
+	records   []bufferedRecord
+	dropped   int // Records dropped since the last record queued.
+	closed    bool
+	abandoned bool
+
+	ready chan struct{} // Signalled when records are queued or the buffer closes.
+	space chan struct{} // Signalled when records are taken.
+	gone  chan struct{} // Closed when the buffer is abandoned.
+}
+
+func newRecordBuffer(policy *BufferPolicy) *recordBuffer {
+	return &recordBuffer{
+		size:     policy.size(),
+		overflow: policy.Overflow,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		gone:     make(chan struct{}),
+	}
+}
+
+// push queues a record, returning the number of records dropped to
+// do so. While the buffer is full it blocks, or drops a record,
+// according to the overflow policy. A blocked push waits for the
+// writing goroutine even once the stream has been cancelled, as it
+// goes on taking records until the buffer is closed and empty; it
+// gives up only if the buffer is abandoned. Records pushed to an
+// abandoned buffer are dropped.
+func (b *recordBuffer) push(record LogRecord) int {
+	b.mu.Lock()
+
+	if b.abandoned {
+		b.dropped++
+		b.mu.Unlock()
+		return 1
+	}
+
+	for len(b.records) >= b.size {
+		switch b.overflow {
+		case OverflowDropNewest:
+			b.dropped++
+			b.mu.Unlock()
+			return 1
+
+		case OverflowDropOldest:
+			oldest := b.records[0]
+			b.records[0] = bufferedRecord{}
+			b.records = b.records[1:]
+
+			// The next record in line, or this one, stands
+			// after the records dropped.
+			if len(b.records) > 0 {
+				b.records[0].dropped += oldest.dropped + 1
+			} else {
+				b.dropped += oldest.dropped + 1
+			}
+
+			b.queue(record)
+			b.mu.Unlock()
+			return 1
+
+		default:
+			b.mu.Unlock()
+
+			select {
+			case <-b.space:
+			case <-b.gone:
+			}
+
+			b.mu.Lock()
+
+			if b.abandoned {
+				b.dropped++
+				b.mu.Unlock()
+				return 1
+			}
+		}
+	}
+
+	b.queue(record)
+	b.mu.Unlock()
+
+	return 0
+}
+
+// queue appends a record, carrying the count of records dropped
+// before it. Called with mu held.
+func (b *recordBuffer) queue(record LogRecord) {
+	b.records = append(b.records, bufferedRecord{record: record, dropped: b.dropped})
+	b.dropped = 0
+	signal(b.ready)
+}
+
+// take waits for the next record. It returns false once the buffer
+// is closed and empty, with the number of records dropped after the
+// last one taken.
+func (b *recordBuffer) take() (next bufferedRecord, ok bool) {
+	for {
+		b.mu.Lock()
+
+		if len(b.records) > 0 {
+			next = b.records[0]
+			b.records[0] = bufferedRecord{}
+			b.records = b.records[1:]
+			b.mu.Unlock()
+
+			signal(b.space)
+
+			return next, true
+		}
+
+		if b.closed {
+			dropped := b.dropped
+			b.dropped = 0
+			b.mu.Unlock()
+
+			return bufferedRecord{dropped: dropped}, false
+		}
+
+		b.mu.Unlock()
+
+		<-b.ready
+	}
+}
+
+// close marks the end of the records; those already queued are
+// still taken.
+func (b *recordBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	signal(b.ready)
+}
+
+// abandon closes the buffer, dropping the records queued, and stops
+// pushes from blocking. It returns the number of records dropped,
+// which the writing goroutine marks if it is still writing.
+func (b *recordBuffer) abandon() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.abandoned {
+		return 0
+	}
+
+	dropped := len(b.records)
+	for _, queued := range b.records {
+		b.dropped += queued.dropped + 1
+	}
+
+	b.records = nil
+	b.closed = true
+	b.abandoned = true
+	close(b.gone)
+	signal(b.ready)
+
+	return dropped
+}
+
+// signal wakes the goroutine waiting on ch, if any.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,325 @@
+package kat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// drainBuffer closes a buffer and returns what the writer would
+// write: the lines taken, preceded by "-N" where N records were
+// dropped.
+func drainBuffer(b *recordBuffer) []string {
+	b.close()
+
+	var lines []string
+	for {
+		next, ok := b.take()
+		if next.dropped > 0 {
+			lines = append(lines, fmt.Sprintf("-%d", next.dropped))
+		}
+
+		if !ok {
+			return lines
+		}
+
+		lines = append(lines, next.record.Line)
+	}
+}
+
+func TestRecordBuffer_Overflow(t *testing.T) {
+	tests := []struct {
+		overflow OverflowPolicy
+		dropped  int
+		expected []string
+	}{
+		{
+			overflow: OverflowDropNewest,
+			dropped:  3,
+			expected: []string{"a", "b", "-3"},
+		},
+		{
+			overflow: OverflowDropOldest,
+			dropped:  3,
+			expected: []string{"-3", "d", "e"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.overflow.String(), func(t *testing.T) {
+			b := newRecordBuffer(&BufferPolicy{Size: 2, Overflow: tt.overflow})
+
+			dropped := 0
+			for _, line := range []string{"a", "b", "c", "d", "e"} {
+				dropped += b.push(LogRecord{Line: line})
+			}
+
+			if dropped != tt.dropped {
+				t.Errorf("expected %d records dropped, got %d", tt.dropped, dropped)
+			}
+
+			if lines := drainBuffer(b); !slices.Equal(lines, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, lines)
+			}
+		})
+	}
+}
+
+func TestRecordBuffer_Block(t *testing.T) {
+	b := newRecordBuffer(&BufferPolicy{Size: 1, Overflow: OverflowBlock})
+
+	b.push(LogRecord{Line: "a"})
+
+	pushed := make(chan int, 1)
+	go func() { pushed <- b.push(LogRecord{Line: "b"}) }()
+
+	select {
+	case <-pushed:
+		t.Fatal("expected the push to block while the buffer is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if next, _ := b.take(); next.record.Line != "a" {
+		t.Fatalf("expected to take a, got %q", next.record.Line)
+	}
+
+	if dropped := <-pushed; dropped != 0 {
+		t.Errorf("expected no records dropped, got %d", dropped)
+	}
+
+	if lines := drainBuffer(b); !slices.Equal(lines, []string{"b"}) {
+		t.Errorf("expected b to be buffered, got %q", lines)
+	}
+}
+
+func TestStartWriter_SlowSink(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		records []LogRecord
+	)
+
+	unblock := make(chan struct{})
+	sink := SinkFunc(func(record LogRecord) error {
+		<-unblock
+
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+
+		return nil
+	})
+
+	k := New(nil, nil, &OutputConfig{
+		Buffer: &BufferPolicy{Size: 2, Overflow: OverflowDropNewest},
+		Sinks:  []Sink{sink},
+	}, nil)
+
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	stop := k.startWriter(cs)
+
+	// Reading goes on while the sink is stuck on the first line.
+	input := strings.Repeat("line\n", 10)
+	if _, err := k.copyLines(cs, 0, strings.NewReader(input), &logCursor{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	close(unblock)
+	stop()
+
+	var lines []string
+	for _, record := range records {
+		lines = append(lines, record.Line)
+	}
+
+	// The writer holds one line while two more are buffered.
+	dropped := int(k.StreamStats().Dropped)
+	if dropped < 7 || len(lines) != 10-dropped+1 {
+		t.Fatalf("expected at least 7 lines dropped and a marker, got %d dropped and %q", dropped, lines)
+	}
+
+	if last := records[len(records)-1]; last.Dropped != dropped || last.Line != fmt.Sprintf("[%d lines dropped]", dropped) {
+		t.Errorf("expected a marker for the %d lines dropped, got %+v", dropped, last)
+	}
+}
+
+func TestStopStreaming_DrainsBuffers(t *testing.T) {
+	const lines = 50
+
+	var (
+		written  atomic.Int32
+		atClose  int32
+		received = make(chan struct{}, lines)
+	)
+
+	sink := &closingSink{
+		write: func(LogRecord) error {
+			time.Sleep(time.Millisecond)
+			written.Add(1)
+			received <- struct{}{}
+			return nil
+		},
+		close: func() error {
+			atClose = written.Load()
+			return nil
+		},
+	}
+
+	k := New(nil, nil, &OutputConfig{
+		Buffer: &BufferPolicy{Size: 2, Overflow: OverflowBlock},
+		Sinks:  []Sink{sink},
+	}, nil)
+
+	// Stream lines as streamContainer does, through a buffer behind
+	// the slow sink.
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	k.spawn(func() {
+		defer k.startWriter(cs)()
+
+		input := strings.Repeat("line\n", lines)
+		if _, err := k.copyLines(cs, 0, strings.NewReader(input), &logCursor{}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	<-received
+
+	if err := k.StopStreaming(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if atClose != lines {
+		t.Errorf("expected all %d lines written before the sink was closed, got %d", lines, atClose)
+	}
+
+	// No streams start once stopped.
+	started := false
+	k.spawn(func() { started = true })
+
+	if k.streams.Wait(); started {
+		t.Errorf("expected no stream to start after StopStreaming")
+	}
+}
+
+func TestStopStreaming_StuckSink(t *testing.T) {
+	const lines = 20
+
+	var (
+		mu      sync.Mutex
+		records []LogRecord
+		closed  atomic.Bool
+	)
+
+	unblock := make(chan struct{})
+	sink := &closingSink{
+		write: func(record LogRecord) error {
+			<-unblock
+
+			mu.Lock()
+			defer mu.Unlock()
+			records = append(records, record)
+
+			return nil
+		},
+		close: func() error {
+			closed.Store(true)
+			return nil
+		},
+	}
+
+	k := New(nil, nil, &OutputConfig{
+		Buffer:      &BufferPolicy{Size: 10, Overflow: OverflowBlock},
+		Sinks:       []Sink{sink},
+		StopTimeout: 50 * time.Millisecond,
+	}, nil)
+
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	k.spawn(func() {
+		defer k.startWriter(cs)()
+
+		input := strings.Repeat("line\n", lines)
+		if _, err := k.copyLines(cs, 0, strings.NewReader(input), &logCursor{}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- k.StopStreaming() }()
+
+	select {
+	case err := <-stopped:
+		if err == nil || !closed.Load() {
+			t.Errorf("expected StopStreaming to give up on the stuck sink and close it, got closed=%v err=%v", closed.Load(), err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected StopStreaming to return while the sink is stuck")
+	}
+
+	// The writer is stuck on the first line; the rest are dropped,
+	// whether buffered or read after the buffer was abandoned.
+	waitFor(t, "lines to be dropped", func() bool { return k.StreamStats().Dropped == lines-1 })
+
+	close(unblock)
+	k.streams.Wait()
+
+	if len(records) != 2 || records[1].Dropped != lines-1 {
+		t.Errorf("expected the first line and a marker for the rest, got %+v", records)
+	}
+}
+
+// closingSink is a sink that is closed by StopStreaming.
+type closingSink struct {
+	write func(LogRecord) error
+	close func() error
+}
+
+func (s *closingSink) WriteRecord(record LogRecord) error {
+	return s.write(record)
+}
+
+func (s *closingSink) Close() error {
+	return s.close()
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDropNewest} {
+		if parsed, err := ParseOverflowPolicy(policy.String()); err != nil || parsed != policy {
+			t.Errorf("expected %s to round trip, got %v, %v", policy, parsed, err)
+		}
+	}
+
+	if _, err := ParseOverflowPolicy("drop-all"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
+
+func TestStreamPodLogs_Buffered(t *testing.T) {
+	dir := t.TempDir()
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, _ := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{
+		TeeDir: dir,
+		Buffer: &BufferPolicy{},
+	}, rec.callbacks())
+
+	stream := newPodStream(context.Background(), podLister(t, pod))
+	k.streamPodLogs(stream, pod, time.Now().Add(-time.Minute))
+
+	waitFor(t, "line", func() bool { return rec.hasLine("default/web-0:app " + fakeLogLine) })
+	stream.cancel()
+
+	// The writer closes the file once the stream ends.
+	waitFor(t, "tee file to be closed", func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.created) == 1 && len(rec.closed) == 1
+	})
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -14,19 +17,32 @@ import (
 
 	"github.com/frobware/kat"
 	"github.com/frobware/kat/namespace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// excludeFlags implements flag.Value to handle repeatable --exclude
-// flags.
-type excludeFlags []string
+// maxNamespaceWatches is the number of namespaces named on the
+// command line beyond which a single cluster-wide pod watch is used
+// rather than a watch per namespace.
+const maxNamespaceWatches = 10
 
-func (e *excludeFlags) String() string {
+// redacted replaces text matching --redact.
+const redacted = "[REDACTED]"
+
+// patternFlags implements flag.Value to handle repeatable,
+// comma-separated pattern flags such as --exclude.
+type patternFlags []string
+
+func (e *patternFlags) String() string {
 	return strings.Join(*e, ",")
 }
 
-func (e *excludeFlags) Set(value string) error {
+func (e *patternFlags) Set(value string) error {
 	patterns := strings.Split(value, ",")
 
 	for _, pattern := range patterns {
@@ -39,6 +55,31 @@ func (e *excludeFlags) Set(value string) error {
 	return nil
 }
 
+// regexpFlags implements flag.Value to handle repeatable regular
+// expression flags such as --grep. Values are not split on commas,
+// which are common in expressions.
+type regexpFlags []*regexp.Regexp
+
+func (r *regexpFlags) String() string {
+	patterns := make([]string, len(*r))
+	for i, pattern := range *r {
+		patterns[i] = pattern.String()
+	}
+
+	return strings.Join(patterns, " ")
+}
+
+func (r *regexpFlags) Set(value string) error {
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+
+	*r = append(*r, pattern)
+
+	return nil
+}
+
 // streamingHandler manages namespace-specific streaming
 type streamingHandler struct {
 	katInstance   *kat.Kat
@@ -109,19 +150,56 @@ func (h *streamingHandler) Stop() {
 }
 
 func main() {
-	qps := flag.Float64("qps", 500, "Kubernetes client QPS")
-	burst := flag.Int("burst", 1000, "Kubernetes client burst")
+	qps := flag.Float64("qps", 500, "Maximum Kubernetes client QPS, reduced automatically while the API server is throttling")
+	burst := flag.Int("burst", 1000, "Maximum Kubernetes client burst")
 	kubeconfig := flag.String("kubeconfig", "", "Path to kubeconfig")
 	since := flag.Duration("since", time.Minute, "Show logs since duration (e.g., 5m)")
 	silent := flag.Bool("silent", false, "Disable console output for log lines")
 	teeDir := flag.String("tee", "", "Directory to write logs to (optional)")
 	useTempDir := flag.Bool("d", false, "Automatically create a temporary directory for logs")
+	onTeeFailure := flag.String("on-tee-failure", kat.TeeFailureRetry.String(), "What to do when writing log files fails, such as when the disk is full: continue (console only), stop, or retry")
+	maxDisk := flag.String("max-disk", "", "Maximum bytes to write to the log directory, such as 500Mi or 10Gi (default: unlimited)")
 	allowExisting := flag.Bool("allow-existing", false, "Allow logging to an existing directory (default: false)")
 	showVersion := flag.Bool("version", false, "Show version information")
 	allNamespaces := flag.Bool("A", false, "Watch all namespaces")
 
-	var excludePatterns excludeFlags
-	flag.Var(&excludePatterns, "exclude", "Comma-separated namespace patterns to exclude (repeatable)")
+	var namespaceFlag string
+	flag.StringVar(&namespaceFlag, "n", "", "Namespace for workload arguments, or to watch if none are given (same as --namespace)")
+	flag.StringVar(&namespaceFlag, "namespace", "", "Namespace for workload arguments, or to watch if none are given (default: current context)")
+	initContainers := flag.Bool("init-containers", true, "Stream init containers, including native sidecars")
+	ephemeralContainers := flag.Bool("ephemeral-containers", true, "Stream ephemeral (debug) containers")
+	retryMax := flag.Int("retry-max", -1, "Consecutive reconnect attempts before giving up on a stream (-1 for unlimited)")
+	retryDelay := flag.Duration("retry-delay", 100*time.Millisecond, "Initial delay between reconnect attempts")
+	retryMaxDelay := flag.Duration("retry-max-delay", 30*time.Second, "Maximum delay between reconnect attempts")
+	idleTimeout := flag.Duration("idle-timeout", 5*time.Minute, "Re-establish log streams that deliver no data for this long (0 to disable)")
+	maxLineLength := flag.Int("max-line-length", kat.DefaultMaxLineLength, "Maximum length of a log line in bytes")
+	longLines := flag.String("long-lines", kat.LongLineSplit.String(), "How to handle lines longer than --max-line-length: split or truncate")
+	maxStreams := flag.Int("max-streams", 0, "Maximum number of containers streamed at once (0 for unlimited)")
+	streamStartRate := flag.Float64("stream-start-rate", 50, "Container streams started per second (0 for unlimited)")
+	streamStartBurst := flag.Int("stream-start-burst", 100, "Container streams that may start at once within --stream-start-rate")
+	statsInterval := flag.Duration("stats-interval", 30*time.Second, "How often to log the number of active and queued streams when it changes (0 to disable)")
+	watchList := flag.Bool("watch-list", false, "Fill informer caches with streaming watch-list requests, lowering peak memory on large clusters (falls back to lists if unsupported)")
+	bufferSize := flag.Int("buffer-size", kat.DefaultBufferSize, "Log lines buffered per container between reading and output (0 to write before reading on)")
+	overflow := flag.String("overflow", kat.OverflowBlock.String(), "What to do when a container's buffer is full: block, drop-oldest or drop-newest")
+	quietAfter := flag.Duration("quiet-after", 0, "Warn when a container that has logged is silent for this long (0 to disable)")
+
+	var labelSelector string
+	flag.StringVar(&labelSelector, "l", "", "Label selector to filter pods (e.g., app=foo,tier!=cache)")
+	flag.StringVar(&labelSelector, "selector", "", "Label selector to filter pods (same as -l)")
+	fieldSelector := flag.String("field-selector", "", "Field selector to filter pods (e.g., spec.nodeName=worker-3)")
+
+	var excludePatterns patternFlags
+	flag.Var(&excludePatterns, "exclude", "Comma-separated namespace[/pod][:container] patterns to exclude (repeatable)")
+
+	var containerPatterns, excludeContainerPatterns patternFlags
+	flag.Var(&containerPatterns, "c", "Comma-separated container name patterns to stream (same as --container)")
+	flag.Var(&containerPatterns, "container", "Comma-separated container name patterns to stream (repeatable)")
+	flag.Var(&excludeContainerPatterns, "exclude-container", "Comma-separated container name patterns to exclude (repeatable)")
+
+	var grepPatterns, grepExcludePatterns, redactPatterns regexpFlags
+	flag.Var(&grepPatterns, "grep", "Show only lines matching the regular expression (repeatable; lines matching any are shown)")
+	flag.Var(&grepExcludePatterns, "grep-v", "Hide lines matching the regular expression (repeatable)")
+	flag.Var(&redactPatterns, "redact", "Replace text matching the regular expression with "+redacted+" (repeatable)")
 
 	flag.Parse()
 
@@ -160,6 +238,31 @@ func main() {
 		}
 	}
 
+	teeFailurePolicy, err := kat.ParseTeeFailurePolicy(*onTeeFailure)
+	if err != nil {
+		log.Fatalf("Invalid --on-tee-failure: %v", err)
+	}
+
+	var maxDiskBytes int64
+	if *maxDisk != "" {
+		quantity, err := resource.ParseQuantity(*maxDisk)
+		if err != nil || quantity.Sign() <= 0 {
+			log.Fatalf("Invalid --max-disk %q: expected a positive size such as 500Mi", *maxDisk)
+		}
+
+		maxDiskBytes = quantity.Value()
+	}
+
+	if *teeDir != "" {
+		checkFreeSpace(*teeDir, maxDiskBytes)
+	}
+
+	if *watchList {
+		// client-go reads its feature gates from the environment
+		// the first time one is checked.
+		os.Setenv("KUBE_FEATURE_WatchListClient", "true")
+	}
+
 	kubeconfigPath := *kubeconfig
 	if kubeconfigPath == "" {
 		kubeconfigPath = clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
@@ -170,20 +273,27 @@ func main() {
 		log.Fatalf("Error loading kubeconfig: %v", err)
 	}
 
-	config.QPS = float32(*qps)
-	config.Burst = *burst
+	// The throttle replaces the client's fixed rate limit, backing
+	// off whenever the API server rejects requests as too many.
+	throttle := kat.NewThrottle(*qps, *burst)
+	config.RateLimiter = throttle
+	config.Wrap(throttle.WrapTransport)
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error creating Kubernetes client: %v", err)
 	}
 
-	var includePatternStrings []string
-	args := flag.Args()
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Error creating Kubernetes metadata client: %v", err)
+	}
+
+	currentNamespace := func() string {
+		if namespaceFlag != "" {
+			return namespaceFlag
+		}
 
-	if *allNamespaces {
-		includePatternStrings = []string{}
-	} else if len(args) == 0 {
 		namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 			clientcmd.NewDefaultClientConfigLoadingRules(),
 			&clientcmd.ConfigOverrides{},
@@ -191,9 +301,38 @@ func main() {
 		if err != nil {
 			log.Fatalf("Error determining current namespace: %v", err)
 		}
-		includePatternStrings = []string{namespace}
-	} else {
-		includePatternStrings = args
+
+		return namespace
+	}
+
+	var (
+		includePatternStrings []string
+		workloads             []kat.Workload
+	)
+
+	for _, arg := range flag.Args() {
+		workload, ok, err := kat.ParseWorkload(arg)
+		if err != nil {
+			log.Fatalf("Error parsing workload: %v", err)
+		}
+
+		if !ok {
+			includePatternStrings = append(includePatternStrings, arg)
+			continue
+		}
+
+		workload.Namespace = currentNamespace()
+		workloads = append(workloads, workload)
+	}
+
+	if len(workloads) > 0 && (*allNamespaces || len(includePatternStrings) > 0) {
+		log.Fatalf("Workload arguments cannot be combined with namespace patterns or -A")
+	}
+
+	if *allNamespaces {
+		includePatternStrings = []string{}
+	} else if len(includePatternStrings) == 0 && len(workloads) == 0 {
+		includePatternStrings = []string{currentNamespace()}
 	}
 
 	includePatterns, err := namespace.ParsePatterns(includePatternStrings)
@@ -206,12 +345,105 @@ func main() {
 		log.Fatalf("Error parsing exclude patterns: %v", err)
 	}
 
+	longLineMode, err := kat.ParseLongLineMode(*longLines)
+	if err != nil {
+		log.Fatalf("Invalid --long-lines: %v", err)
+	}
+
+	overflowPolicy, err := kat.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		log.Fatalf("Invalid --overflow: %v", err)
+	}
+
+	parsedContainerPatterns, err := namespace.ParseNamePatterns(containerPatterns)
+	if err != nil {
+		log.Fatalf("Error parsing container patterns: %v", err)
+	}
+
+	parsedExcludeContainerPatterns, err := namespace.ParseNamePatterns(excludeContainerPatterns)
+	if err != nil {
+		log.Fatalf("Error parsing exclude container patterns: %v", err)
+	}
+
+	targets := namespace.NewSelector(includePatterns, parsedExcludePatterns).
+		WithContainers(parsedContainerPatterns, parsedExcludeContainerPatterns)
+
+	streamCfg := &kat.StreamConfig{
+		InitContainers:      *initContainers,
+		EphemeralContainers: *ephemeralContainers,
+		Retry: &kat.RetryPolicy{
+			MaxRetries:   *retryMax,
+			InitialDelay: *retryDelay,
+			MaxDelay:     *retryMaxDelay,
+		},
+		Admission: &kat.AdmissionPolicy{
+			MaxStreams: *maxStreams,
+			StartRate:  *streamStartRate,
+			StartBurst: *streamStartBurst,
+		},
+		Throttle:      throttle,
+		MaxLineLength: *maxLineLength,
+		LongLines:     longLineMode,
+		IdleTimeout:   *idleTimeout,
+		QuietPeriod:   *quietAfter,
+		LabelSelector: labelSelector,
+		FieldSelector: *fieldSelector,
+		Targets:       targets,
+		Metadata: &kat.MetadataClient{
+			Client: metadataClient,
+			Mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())),
+		},
+	}
+
+	if err := streamCfg.Validate(); err != nil {
+		log.Fatalf("Invalid pod selection: %v", err)
+	}
+
 	outputCfg := &kat.OutputConfig{
 		TeeDir: *teeDir,
 		Silent: *silent,
+		TeeOptions: kat.FileSinkOptions{
+			OnFailure: teeFailurePolicy,
+			MaxBytes:  maxDiskBytes,
+		},
+	}
+
+	if *bufferSize > 0 {
+		outputCfg.Buffer = &kat.BufferPolicy{
+			Size:     *bufferSize,
+			Overflow: overflowPolicy,
+		}
+	}
+
+	// Lines are filtered on their original text, before redaction.
+	if len(grepPatterns) > 0 {
+		outputCfg.Processors = append(outputCfg.Processors, kat.NewGrep(grepPatterns, false))
 	}
 
-	k := kat.New(clientset, outputCfg, &kat.Callbacks{
+	if len(grepExcludePatterns) > 0 {
+		outputCfg.Processors = append(outputCfg.Processors, kat.NewGrep(grepExcludePatterns, true))
+	}
+
+	if len(redactPatterns) > 0 {
+		outputCfg.Processors = append(outputCfg.Processors, kat.NewRedactor(redactPatterns, redacted))
+	}
+
+	if !*silent {
+		outputCfg.Sinks = append(outputCfg.Sinks, kat.NewConsoleSink(os.Stdout))
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// A log file write failure under --on-tee-failure=stop shuts
+	// down as an interrupt would, and then exits with the failure.
+	ctx, halt := context.WithCancelCause(signalCtx)
+	defer halt(nil)
+
+	k := kat.New(clientset, streamCfg, outputCfg, &kat.Callbacks{
+		OnContainerQuiet: func(namespace, podName, containerName string, lastLine time.Time) {
+			log.Printf("Container has gone quiet: %s/%s:%s has not logged since %s", namespace, podName, containerName, lastLine.Format(time.RFC3339))
+		},
 		OnError: func(err error) {
 			log.Printf("Error: %v", err)
 		},
@@ -221,10 +453,11 @@ func main() {
 		OnFileCreated: func(filePath string) {
 			log.Println("Created log file", filePath)
 		},
-		OnLogLine: func(namespace, podName, containerName, line string) {
-			if !*silent {
-				fmt.Printf("[%s/%s:%s] %s\n", namespace, podName, containerName, line)
-			}
+		OnLogGap: func(namespace, podName, containerName string, after time.Time) {
+			log.Printf("Log lines may be missing: %s/%s:%s after %s", namespace, podName, containerName, after.Format(time.RFC3339Nano))
+		},
+		OnLongLine: func(namespace, podName, containerName string, length int) {
+			log.Printf("Long log line (%d bytes, %s): %s/%s:%s", length, longLineMode, namespace, podName, containerName)
 		},
 		OnStreamStart: func(namespace, podName, containerName string) {
 			log.Printf("Started streaming logs: %s/%s:%s", namespace, podName, containerName)
@@ -232,59 +465,195 @@ func main() {
 		OnStreamStop: func(namespace, podName, containerName string) {
 			log.Printf("Stopped streaming logs: %s/%s:%s", namespace, podName, containerName)
 		},
-	})
+		OnStreamRetry: func(namespace, podName, containerName string, attempt int, delay time.Duration, err error) {
+			log.Printf("Retrying stream %s/%s:%s (attempt %d) in %s: %v", namespace, podName, containerName, attempt, delay.Round(time.Millisecond), err)
+		},
+		OnStreamReconnect: func(namespace, podName, containerName string, attempt int) {
+			log.Printf("Reconnected stream %s/%s:%s after %d attempts", namespace, podName, containerName, attempt)
+		},
+		OnThrottled: func(qps float64, retryAfter time.Duration, fairness bool) {
+			source := "the API server"
+			if fairness {
+				source = "API Priority and Fairness"
+			}
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+			log.Printf("Throttled by %s: pausing requests for %s and slowing to %.1f QPS", source, retryAfter, qps)
+		},
+		OnThrottleRecovered: func(qps float64) {
+			log.Printf("No longer throttled: back to %.1f QPS", qps)
+		},
+		OnTeeFailed: func(err error, action kat.TeeFailurePolicy) {
+			switch action {
+			case kat.TeeFailureStop:
+				log.Printf("Error writing log files, stopping: %v", err)
+				halt(err)
+			case kat.TeeFailureRetry:
+				log.Printf("Error writing log files, pausing and retrying every %s: %v", kat.DefaultTeeRetryInterval, err)
+			default:
+				log.Printf("Error writing log files, continuing without them: %v", err)
+			}
+		},
+		OnTeeResumed: func(lost int) {
+			log.Printf("Writing log files again; %d lines were not written", lost)
+		},
+	})
 
-	needsDiscovery := *allNamespaces || len(parsedExcludePatterns) > 0
-	if !needsDiscovery {
+	// Only -A and namespace globs need namespaces to be discovered.
+	// Exclude patterns are applied within the watches of the
+	// namespaces named, which also drop the excluded namespaces.
+	needsDiscovery := len(workloads) == 0 && *allNamespaces
+	if !needsDiscovery && len(workloads) == 0 {
 		for _, pattern := range includePatterns {
-			if strings.ContainsAny(pattern.String(), "*?[]") {
+			if strings.ContainsAny(pattern.Namespace(), "*?[]") {
 				needsDiscovery = true
 				break
 			}
 		}
 	}
 
-	if needsDiscovery {
-		handler := newStreamingHandler(k, *since)
-		watcher := namespace.NewInformerWatcher(clientset)
-
-		go func() {
-			<-ctx.Done()
-			log.Println("Shutting down...")
-			handler.Stop()
-			watcher.Stop()
-			if err := k.StopStreaming(); err != nil {
-				log.Printf("Error stopping streaming: %v", err)
+	var namespaceNames []string
+	if !needsDiscovery {
+		for _, pattern := range includePatterns {
+			name := pattern.Namespace()
+			if targets.IncludesNamespace(name) && !slices.Contains(namespaceNames, name) {
+				namespaceNames = append(namespaceNames, name)
 			}
-		}()
-
-		if err := watcher.Start(ctx, includePatterns, parsedExcludePatterns, handler); err != nil {
-			log.Fatalf("Error starting namespace watcher: %v", err)
 		}
+	}
+
+	if *statsInterval > 0 {
+		go logStreamStats(ctx, k, *statsInterval)
+	}
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
 
 		<-ctx.Done()
-		log.Println("Shutdown complete")
-	} else {
-		var namespaceNames []string
-		for _, pattern := range includePatterns {
-			namespaceNames = append(namespaceNames, pattern.String())
+		log.Println("Shutting down...")
+		if err := k.StopStreaming(); err != nil {
+			log.Printf("Error stopping streaming: %v", err)
 		}
+	}()
 
-		go func() {
-			<-ctx.Done()
-			log.Println("Shutting down...")
-			if err := k.StopStreaming(); err != nil {
-				log.Printf("Error stopping streaming: %v", err)
+	switch {
+	case len(workloads) > 0:
+		err = k.StartStreamingWorkloads(ctx, workloads, *since)
+	case needsDiscovery || len(namespaceNames) > maxNamespaceWatches:
+		// One cluster-wide pod watch, filtered by the patterns,
+		// is far cheaper for the API server than a watch per
+		// namespace, but needs cluster-wide permissions.
+		err = k.StartStreamingAllNamespaces(ctx, *since)
+		if apierrors.IsForbidden(err) {
+			log.Printf("Cannot watch pods in all namespaces, watching each namespace instead: %v", err)
+
+			if needsDiscovery {
+				err = watchNamespaces(ctx, k, clientset, includePatterns, parsedExcludePatterns, *since)
+			} else {
+				err = k.StartStreaming(ctx, namespaceNames, *since)
 			}
-		}()
+		}
+	default:
+		err = k.StartStreaming(ctx, namespaceNames, *since)
+	}
+
+	// Wait for the log files to be written and closed before
+	// exiting, whether streaming was interrupted or failed.
+	halt(nil)
+	<-stopped
+
+	if err != nil {
+		log.Fatalf("Error starting streaming: %v", err)
+	}
+
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		log.Fatalf("Stopped after failing to write log files: %v", cause)
+	}
+
+	log.Println("Shutdown complete")
+}
+
+// minTeeFreeSpace is the free space below which kat refuses to
+// start writing log files.
+const minTeeFreeSpace = 64 << 20
+
+// checkFreeSpace exits unless the file system holding dir has room
+// for the --max-disk budget, or at least minTeeFreeSpace. Platforms
+// that cannot report free space are not checked.
+func checkFreeSpace(dir string, budget int64) {
+	free, err := kat.FreeDiskSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return
+	}
+
+	if err != nil {
+		log.Fatalf("Error checking free space for %s: %v", dir, err)
+	}
+
+	if need := max(budget, minTeeFreeSpace); free < need {
+		log.Fatalf("Only %s free for %s, need at least %s (see --max-disk)", formatBytes(free), dir, formatBytes(need))
+	}
+}
+
+// formatBytes formats a size in binary units, such as 1.5 GiB.
+func formatBytes(n int64) string {
+	size := float64(n)
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
 
-		if err := k.StartStreaming(ctx, namespaceNames, *since); err != nil {
-			log.Fatalf("Error starting streaming: %v", err)
+// logStreamStats logs the number of active and queued container
+// streams, and of lines dropped from full buffers, every interval in
+// which it has changed, until ctx is done.
+func logStreamStats(ctx context.Context, k *kat.Kat, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last kat.StreamStats
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if stats := k.StreamStats(); stats != last {
+				if stats.Dropped > 0 {
+					log.Printf("Streams: %d active, %d queued, %d lines dropped", stats.Active, stats.Queued, stats.Dropped)
+				} else {
+					log.Printf("Streams: %d active, %d queued", stats.Active, stats.Queued)
+				}
+
+				last = stats
+			}
 		}
+	}
+}
 
-		log.Println("Shutdown complete")
+// watchNamespaces streams the namespaces matching the patterns with
+// a pod watch each, starting and stopping them as namespaces come
+// and go, until ctx is done.
+func watchNamespaces(ctx context.Context, k *kat.Kat, clientset kubernetes.Interface, includePatterns, excludePatterns []*namespace.Pattern, since time.Duration) error {
+	handler := newStreamingHandler(k, since)
+	watcher := namespace.NewInformerWatcher(clientset)
+
+	defer func() {
+		handler.Stop()
+		watcher.Stop()
+	}()
+
+	if err := watcher.Start(ctx, includePatterns, excludePatterns, handler); err != nil {
+		return fmt.Errorf("error starting namespace watcher: %w", err)
 	}
+
+	<-ctx.Done()
+
+	return nil
 }
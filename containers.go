@@ -0,0 +1,80 @@
+package kat
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerHasLogs reports whether the current instance of a
+// container has logs to give after sinceTime: it is running, or it
+// terminated within the window.
+func containerHasLogs(status corev1.ContainerStatus, sinceTime time.Time) bool {
+	if status.State.Running != nil {
+		return true
+	}
+
+	return status.State.Terminated != nil && status.State.Terminated.FinishedAt.After(sinceTime)
+}
+
+// podFinished reports whether all of the pod's containers have
+// terminated for good.
+func podFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// streamableContainers returns the statuses of the pod's containers
+// that have logs to give, restricted to the container classes and
+// targets selected in the stream configuration. Init containers,
+// which include native sidecars, come first in execution order,
+// followed by regular and then ephemeral containers.
+func (k *Kat) streamableContainers(pod *corev1.Pod, sinceTime time.Time) []corev1.ContainerStatus {
+	targets := k.streamConfig.Targets
+	if targets != nil && !targets.IncludesPod(pod.Namespace, pod.Name) {
+		return nil
+	}
+
+	var streamable []corev1.ContainerStatus
+
+	appendStreamable := func(statuses []corev1.ContainerStatus) {
+		for _, status := range statuses {
+			if targets != nil && !targets.IncludesContainer(pod.Namespace, pod.Name, status.Name) {
+				continue
+			}
+
+			if containerHasLogs(status, sinceTime) {
+				streamable = append(streamable, status)
+			}
+		}
+	}
+
+	if k.streamConfig.InitContainers {
+		appendStreamable(pod.Status.InitContainerStatuses)
+	}
+
+	appendStreamable(pod.Status.ContainerStatuses)
+
+	if k.streamConfig.EphemeralContainers {
+		appendStreamable(pod.Status.EphemeralContainerStatuses)
+	}
+
+	return streamable
+}
+
+// hasNewContainerInstances reports whether newPod has a selected
+// container instance with logs to give that oldPod did not: either
+// the container has started, or it has restarted.
+func (k *Kat) hasNewContainerInstances(oldPod, newPod *corev1.Pod, sinceTime time.Time) bool {
+	restartCounts := make(map[string]int32)
+	for _, status := range k.streamableContainers(oldPod, sinceTime) {
+		restartCounts[status.Name] = status.RestartCount
+	}
+
+	for _, status := range k.streamableContainers(newPod, sinceTime) {
+		if restartCount, ok := restartCounts[status.Name]; !ok || status.RestartCount > restartCount {
+			return true
+		}
+	}
+
+	return false
+}
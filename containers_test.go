@@ -0,0 +1,158 @@
+package kat
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frobware/kat/namespace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func statusNames(statuses []corev1.ContainerStatus) []string {
+	names := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		names = append(names, status.Name)
+	}
+
+	return names
+}
+
+func selector(t *testing.T, include, exclude []string) *namespace.Selector {
+	t.Helper()
+
+	includePatterns, err := namespace.ParsePatterns(include)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	excludePatterns, err := namespace.ParsePatterns(exclude)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return namespace.NewSelector(includePatterns, excludePatterns)
+}
+
+func TestStreamableContainers(t *testing.T) {
+	sinceTime := time.Now().Add(-time.Minute)
+
+	running := containerState(corev1.PodRunning)
+	terminated := containerState(corev1.PodSucceeded)
+	waiting := containerState(corev1.PodPending)
+	expired := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+		FinishedAt: metav1.NewTime(sinceTime.Add(-time.Second)),
+	}}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop-eu", Name: "web-0"},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "migrate", State: terminated},
+				{Name: "sidecar", State: running},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: running},
+				{Name: "worker", State: waiting},
+				{Name: "cron", State: terminated},
+				{Name: "oneshot", State: expired},
+			},
+			EphemeralContainerStatuses: []corev1.ContainerStatus{
+				{Name: "debugger", State: running},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		config   StreamConfig
+		expected []string
+	}{
+		{
+			name:     "regular containers only",
+			config:   StreamConfig{},
+			expected: []string{"app", "cron"},
+		},
+		{
+			name:     "with init containers",
+			config:   StreamConfig{InitContainers: true},
+			expected: []string{"migrate", "sidecar", "app", "cron"},
+		},
+		{
+			name:     "with ephemeral containers",
+			config:   StreamConfig{EphemeralContainers: true},
+			expected: []string{"app", "cron", "debugger"},
+		},
+		{
+			name:     "all container classes",
+			config:   StreamConfig{InitContainers: true, EphemeralContainers: true},
+			expected: []string{"migrate", "sidecar", "app", "cron", "debugger"},
+		},
+		{
+			name:     "targeted container",
+			config:   StreamConfig{InitContainers: true, Targets: selector(t, []string{"shop-*/web-*:app"}, nil)},
+			expected: []string{"app"},
+		},
+		{
+			name:     "excluded container",
+			config:   StreamConfig{InitContainers: true, Targets: selector(t, []string{"shop-*"}, []string{"shop-eu/web-0:sidecar"})},
+			expected: []string{"migrate", "app", "cron"},
+		},
+		{
+			name:     "untargeted pod",
+			config:   StreamConfig{InitContainers: true, Targets: selector(t, []string{"shop-*/api-*"}, nil)},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := New(nil, &tt.config, &OutputConfig{}, nil)
+
+			if got := statusNames(k.streamableContainers(pod, sinceTime)); !slices.Equal(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHasNewContainerInstances(t *testing.T) {
+	sinceTime := time.Now().Add(-time.Minute)
+	k := New(nil, &StreamConfig{InitContainers: true}, &OutputConfig{}, nil)
+
+	oldPod := &corev1.Pod{
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "init", State: containerState(corev1.PodRunning)},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: containerState(corev1.PodPending)},
+			},
+		},
+	}
+
+	if k.hasNewContainerInstances(oldPod, oldPod, sinceTime) {
+		t.Errorf("expected no new instances for an unchanged pod")
+	}
+
+	newPod := oldPod.DeepCopy()
+	newPod.Status.InitContainerStatuses[0].State = containerState(corev1.PodSucceeded)
+
+	if k.hasNewContainerInstances(oldPod, newPod, sinceTime) {
+		t.Errorf("expected a terminated container not to be a new instance")
+	}
+
+	newPod.Status.ContainerStatuses[0].State = containerState(corev1.PodRunning)
+
+	if !k.hasNewContainerInstances(oldPod, newPod, sinceTime) {
+		t.Errorf("expected a started container to be a new instance")
+	}
+
+	restarted := newPod.DeepCopy()
+	restarted.Status.ContainerStatuses[0].RestartCount = 1
+
+	if !k.hasNewContainerInstances(newPod, restarted, sinceTime) {
+		t.Errorf("expected a restarted container to be a new instance")
+	}
+}
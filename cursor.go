@@ -0,0 +1,90 @@
+package kat
+
+import (
+	"strings"
+	"time"
+)
+
+// splitTimestamp splits a log line requested with Timestamps set
+// into the timestamp the kubelet prefixed it with and the line as
+// written by the container. Lines without a valid timestamp are
+// returned unchanged with a zero time.
+func splitTimestamp(line string) (time.Time, string) {
+	prefix, rest, _ := strings.Cut(line, " ")
+
+	timestamp, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return time.Time{}, line
+	}
+
+	return timestamp, rest
+}
+
+// logCursor records the position reached in the log of a container
+// instance, so that a later request can resume from that position
+// without repeating or losing lines.
+//
+// SinceTime is only honoured to the second, so a resumed request
+// replays the lines already received within that second. Those are
+// dropped by matching them against the timestamp of the last line
+// received and the number of lines received carrying it. If the
+// replay does not include the last line received, it has been
+// rotated out of the kubelet's log and the lines that followed it
+// may have been lost.
+type logCursor struct {
+	timestamp time.Time // Timestamp of the last line received.
+	count     int       // Lines received carrying that timestamp.
+
+	// State of the current request.
+	replayed int  // Lines at timestamp replayed so far.
+	resumed  bool // A line past the cursor has been received.
+}
+
+// since returns the SinceTime for a request resuming from the
+// cursor, or sinceTime if nothing has been received yet.
+func (c *logCursor) since(sinceTime time.Time) time.Time {
+	if c.timestamp.IsZero() {
+		return sinceTime
+	}
+
+	return c.timestamp
+}
+
+// begin prepares the cursor for a new request.
+func (c *logCursor) begin() {
+	c.replayed = 0
+	c.resumed = c.timestamp.IsZero()
+}
+
+// accept reports whether a line with the given timestamp is new,
+// advancing the cursor past it if so. It also reports whether the
+// line is the first new line of the request and lines may have been
+// lost before it. Lines without a timestamp are always accepted.
+func (c *logCursor) accept(timestamp time.Time) (accepted, gap bool) {
+	if timestamp.IsZero() {
+		return true, false
+	}
+
+	if !c.resumed {
+		if timestamp.Before(c.timestamp) {
+			return false, false
+		}
+
+		if timestamp.Equal(c.timestamp) && c.replayed < c.count {
+			c.replayed++
+			return false, false
+		}
+
+		c.resumed = true
+		gap = c.replayed < c.count
+	}
+
+	if timestamp.Equal(c.timestamp) {
+		c.count++
+	} else {
+		c.timestamp = timestamp
+		c.count = 1
+	}
+
+	return true, gap
+}
@@ -0,0 +1,146 @@
+package kat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSplitTimestamp(t *testing.T) {
+	tests := []struct {
+		name          string
+		line          string
+		expectTime    string
+		expectMessage string
+	}{
+		{
+			name:          "timestamped line",
+			line:          "2025-01-06T15:30:00.123456789Z hello world",
+			expectTime:    "2025-01-06T15:30:00.123456789Z",
+			expectMessage: "hello world",
+		},
+		{
+			name:          "timestamped empty line",
+			line:          "2025-01-06T15:30:00Z ",
+			expectTime:    "2025-01-06T15:30:00Z",
+			expectMessage: "",
+		},
+		{
+			name:          "missing timestamp",
+			line:          "hello world",
+			expectMessage: "hello world",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp, message := splitTimestamp(tt.line)
+
+			if tt.expectTime == "" {
+				if !timestamp.IsZero() {
+					t.Errorf("expected zero timestamp, got %v", timestamp)
+				}
+			} else if got := timestamp.Format(time.RFC3339Nano); got != tt.expectTime {
+				t.Errorf("expected timestamp %s, got %s", tt.expectTime, got)
+			}
+
+			if message != tt.expectMessage {
+				t.Errorf("expected message %q, got %q", tt.expectMessage, message)
+			}
+		})
+	}
+}
+
+// collectLines runs copyLines over input and returns the lines
+// delivered and the times after which gaps were reported.
+func collectLines(t *testing.T, cursor *logCursor, input string) ([]string, []time.Time) {
+	t.Helper()
+
+	var (
+		lines []string
+		gaps  []time.Time
+	)
+
+	k := New(nil, nil, &OutputConfig{}, &Callbacks{
+		OnLogLine: func(_, _, _, line string) {
+			lines = append(lines, line)
+		},
+		OnLogGap: func(_, _, _ string, after time.Time) {
+			gaps = append(gaps, after)
+		},
+	})
+
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	if _, err := k.copyLines(cs, 0, strings.NewReader(input), cursor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return lines, gaps
+}
+
+func TestCopyLines_ResumeFromCursor(t *testing.T) {
+	cursor := &logCursor{}
+
+	lines, gaps := collectLines(t, cursor, strings.Join([]string{
+		"2025-01-06T15:30:00.1Z one",
+		"2025-01-06T15:30:00.2Z two",
+		"2025-01-06T15:30:00.2Z three",
+	}, "\n"))
+	if strings.Join(lines, ",") != "one,two,three" || len(gaps) != 0 {
+		t.Fatalf("unexpected first request: lines=%v gaps=%v", lines, gaps)
+	}
+
+	if want := "2025-01-06T15:30:00Z"; cursor.since(time.Time{}).Truncate(time.Second).Format(time.RFC3339) != want {
+		t.Errorf("expected resume from %s, got %v", want, cursor.since(time.Time{}))
+	}
+
+	// The reconnect replays the whole second.
+	lines, gaps = collectLines(t, cursor, strings.Join([]string{
+		"2025-01-06T15:30:00.1Z one",
+		"2025-01-06T15:30:00.2Z two",
+		"2025-01-06T15:30:00.2Z three",
+		"2025-01-06T15:30:00.2Z four",
+		"2025-01-06T15:30:01Z five",
+	}, "\n"))
+	if strings.Join(lines, ",") != "four,five" {
+		t.Errorf("expected only new lines, got %v", lines)
+	}
+
+	if len(gaps) != 0 {
+		t.Errorf("expected no gaps, got %v", gaps)
+	}
+}
+
+func TestCopyLines_GapWhenBoundaryLost(t *testing.T) {
+	cursor := &logCursor{}
+
+	collectLines(t, cursor, "2025-01-06T15:30:00Z one\n2025-01-06T15:30:01Z two\n")
+
+	// The log rotated while disconnected: the last line received
+	// is not replayed.
+	lines, gaps := collectLines(t, cursor, "2025-01-06T15:30:05Z six\n2025-01-06T15:30:06Z seven\n")
+	if strings.Join(lines, ",") != "six,seven" {
+		t.Errorf("expected all lines past the cursor, got %v", lines)
+	}
+
+	// The lines lost came after the last line received.
+	lastReceived := time.Date(2025, 1, 6, 15, 30, 1, 0, time.UTC)
+	if len(gaps) != 1 || !gaps[0].Equal(lastReceived) {
+		t.Errorf("expected one gap after %v, got %v", lastReceived, gaps)
+	}
+}
+
+func TestCopyLines_WithoutTimestamps(t *testing.T) {
+	cursor := &logCursor{}
+
+	lines, gaps := collectLines(t, cursor, "one\ntwo\n")
+	if strings.Join(lines, ",") != "one,two" || len(gaps) != 0 {
+		t.Errorf("unexpected result: lines=%v gaps=%v", lines, gaps)
+	}
+
+	if !cursor.timestamp.IsZero() {
+		t.Errorf("expected cursor not to advance, got %v", cursor.timestamp)
+	}
+}
@@ -7,20 +7,24 @@
 package kat
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 // Callbacks provides hooks for progress updates.
@@ -28,51 +32,474 @@ type Callbacks struct {
 	OnError       func(err error)
 	OnFileClosed  func(filePath string)
 	OnFileCreated func(filePath string)
+	OnLogGap      func(namespace, podName, containerName string, after time.Time)
 	OnLogLine     func(namespace, podName, containerName, line string)
 	OnStreamStart func(namespace, podName, containerName string)
 	OnStreamStop  func(namespace, podName, containerName string)
+
+	// OnStreamRetry is called before an interrupted container
+	// stream is retried, with the error that interrupted it and
+	// the delay before the attempt.
+	OnStreamRetry func(namespace, podName, containerName string, attempt int, delay time.Duration, err error)
+
+	// OnStreamReconnect is called when a retried container stream
+	// has been reconnected.
+	OnStreamReconnect func(namespace, podName, containerName string, attempt int)
+
+	// OnLongLine is called when a line longer than the maximum
+	// line length has been split or truncated, with the full
+	// length of the line in bytes.
+	OnLongLine func(namespace, podName, containerName string, length int)
+
+	// OnContainerQuiet is called when a container that has logged
+	// lines logs nothing new for the configured quiet period. It
+	// is called again only after the container has logged again.
+	OnContainerQuiet func(namespace, podName, containerName string, lastLine time.Time)
+
+	// OnThrottled is called when the API server has rejected
+	// requests as too many and the throttle has reduced the
+	// request rate, with the new rate, how long requests are held
+	// back, and whether API Priority and Fairness rejected them.
+	OnThrottled func(qps float64, retryAfter time.Duration, fairness bool)
+
+	// OnThrottleRecovered is called when the request rate has
+	// recovered to its maximum after being throttled.
+	OnThrottleRecovered func(qps float64)
+
+	// OnTeeFailed is called when writing log files fails, or the
+	// disk budget is reached, with the action taken under the
+	// tee failure policy. Under TeeFailureStop the embedder should
+	// stop streaming; the kat command exits.
+	OnTeeFailed func(err error, action TeeFailurePolicy)
+
+	// OnTeeResumed is called when writing log files succeeds
+	// again after a failure under TeeFailureRetry, with the number
+	// of lines that were not written.
+	OnTeeResumed func(lost int)
+}
+
+// streamKey identifies the log stream of a single pod. Pods are
+// keyed by UID rather than name so that identically named pods in
+// different namespaces, or a pod recreated with the name of its
+// predecessor, are tracked independently.
+type streamKey struct {
+	namespace string
+	uid       types.UID
+}
+
+func podStreamKey(pod *corev1.Pod) streamKey {
+	return streamKey{
+		namespace: pod.Namespace,
+		uid:       pod.UID,
+	}
+}
+
+// podStream tracks an active pod log stream and the containers
+// within it that are being streamed. The pod's state is looked up in
+// the informer cache of the watch that found it, so streaming makes
+// no requests to the API server other than for the logs themselves.
+// Once the pod has finished and every container stream has ended,
+// the stream is forgotten.
+type podStream struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	pods       corev1listers.PodLister
+	mu         sync.Mutex
+	containers map[string]*containerStream
+	running    int  // Container streams that have not ended.
+	finished   bool // The pod has finished.
+}
+
+func newPodStream(ctx context.Context, pods corev1listers.PodLister) *podStream {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &podStream{
+		ctx:        ctx,
+		cancel:     cancel,
+		pods:       pods,
+		containers: make(map[string]*containerStream),
+	}
+}
+
+// containerStream tracks the log stream of a single container
+// across restarts. Each container is streamed by one goroutine that
+// follows an instance of the container to EOF and then waits to be
+// woken when a newer instance starts or the pod finishes.
+type containerStream struct {
+	namespace string
+	podName   string
+	podUID    types.UID
+	nodeName  string
+	labels    map[string]string
+	name      string
+	pods      corev1listers.PodLister
+
+	mu                 sync.Mutex
+	restartCount       int32          // Restart count of the latest started instance.
+	previousFinishedAt time.Time      // When the instance before it terminated, if known.
+	priority           streamPriority // Admission priority of the latest instance.
+	finished           bool           // The pod has finished; no instances will follow.
+	wake               chan struct{}
+
+	// Buffers records for the writing goroutine, if output is
+	// buffered (optional).
+	buffer *recordBuffer
+
+	// Owned by the goroutine writing the container's records: the
+	// writing goroutine if output is buffered, or else the
+	// streaming goroutine.
+	output     StreamInfo // The instance whose records the sinks were opened for.
+	outputOpen bool
+	outputs    []Sink // The sinks open for output.
+
+	// Updated by the streaming goroutine and read by its watchdog.
+	lastLine atomic.Int64 // UnixNano of the last new line received.
+	quiet    atomic.Bool  // The container has been reported quiet.
+}
+
+func newContainerStream(pod *corev1.Pod, name string, pods corev1listers.PodLister) *containerStream {
+	return &containerStream{
+		namespace:    pod.Namespace,
+		podName:      pod.Name,
+		podUID:       pod.UID,
+		nodeName:     pod.Spec.NodeName,
+		labels:       pod.Labels,
+		name:         name,
+		pods:         pods,
+		restartCount: -1,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// observe records the latest container status and wakes the
+// streaming goroutine if a new instance has started.
+func (cs *containerStream) observe(status corev1.ContainerStatus) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if status.RestartCount <= cs.restartCount {
+		return
+	}
+
+	cs.restartCount = status.RestartCount
+	cs.previousFinishedAt = time.Time{}
+	cs.priority = containerPriority(status)
+
+	if terminated := status.LastTerminationState.Terminated; terminated != nil {
+		cs.previousFinishedAt = terminated.FinishedAt.Time
+	}
+
+	cs.signal()
+}
+
+// finish records that the pod has finished and wakes the streaming
+// goroutine so that it exits once its current instance is done.
+func (cs *containerStream) finish() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if !cs.finished {
+		cs.finished = true
+		cs.signal()
+	}
+}
+
+func (cs *containerStream) signal() {
+	select {
+	case cs.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (cs *containerStream) state() (restartCount int32, previousFinishedAt time.Time, finished bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.restartCount, cs.previousFinishedAt, cs.finished
+}
+
+// record returns a log record of a line logged by an instance of
+// the container.
+func (cs *containerStream) record(instance int32, timestamp time.Time, line string) LogRecord {
+	return LogRecord{
+		StreamInfo: StreamInfo{
+			Namespace: cs.namespace,
+			PodName:   cs.podName,
+			PodUID:    cs.podUID,
+			Container: cs.name,
+			NodeName:  cs.nodeName,
+			Labels:    cs.labels,
+			Instance:  instance,
+		},
+		Timestamp: timestamp,
+		Received:  time.Now(),
+		Line:      line,
+	}
+}
+
+func (cs *containerStream) admissionPriority() streamPriority {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.priority
 }
 
 // Kat represents the main structure for managing POD log streaming.
 type Kat struct {
-	clientset     *kubernetes.Clientset
+	clientset     kubernetes.Interface
+	streamConfig  *StreamConfig
 	outputConfig  *OutputConfig
 	activeStreams sync.Map
-	openFiles     sync.Map
 	callbacks     *Callbacks
+	owners        *ownerChains
+	scheduler     *streamScheduler
+	processors    []Processor
+	sinks         []Sink
+	buffer        *BufferPolicy
+	dropped       atomic.Int64 // Records dropped from full buffers.
+
+	// Container streams, including their writing goroutines, for
+	// StopStreaming to wait for, and the buffers they write from,
+	// for it to abandon if they do not drain in time. No more
+	// streams are started once stopped.
+	streams sync.WaitGroup
+	stopMu  sync.Mutex
+	stopped bool
+	buffers map[*recordBuffer]struct{}
+}
+
+// StreamConfig encapsulates configuration for selecting which
+// containers are streamed and how their streams are maintained.
+// Regular containers are always streamed.
+type StreamConfig struct {
+	InitContainers      bool         // Stream init containers, including native sidecars.
+	EphemeralContainers bool         // Stream ephemeral (debug) containers.
+	Retry               *RetryPolicy // Reconnection policy (optional; defaults to DefaultRetryPolicy).
+
+	// Admission limits the containers streamed at once and the
+	// rate at which streams start (optional; nil streams every
+	// container as soon as it is found).
+	Admission *AdmissionPolicy
+
+	// Throttle is the throttle installed in the client's
+	// configuration, if any. Stream admission slows and pauses
+	// while it is throttled, and its changes are reported through
+	// the callbacks.
+	Throttle *Throttle
+
+	// LabelSelector and FieldSelector restrict the pods streamed,
+	// using the same syntax as kubectl's --selector and
+	// --field-selector. Empty selectors match every pod.
+	LabelSelector string
+	FieldSelector string
+
+	// Targets restricts the pods and containers streamed by name
+	// (optional; nil streams every pod and container).
+	Targets TargetSelector
+
+	// Metadata is used to follow ownership chains through
+	// arbitrary resources (optional; required for WorkloadOwner).
+	Metadata *MetadataClient
+
+	// IdleTimeout is how long a log request may go without
+	// delivering data before it is assumed to have stalled and is
+	// re-established. Zero disables the check.
+	IdleTimeout time.Duration
+
+	// MaxLineLength bounds the length of the lines delivered, in
+	// bytes including the timestamp prefix the kubelet adds.
+	// Longer lines are handled according to LongLines. Zero
+	// selects DefaultMaxLineLength.
+	MaxLineLength int
+	LongLines     LongLineMode
+
+	// QuietPeriod is how long a container that has logged may go
+	// without logging before OnContainerQuiet is called. Zero
+	// disables the check.
+	QuietPeriod time.Duration
+}
+
+// TargetSelector selects pods and containers to stream by name.
+// namespace.Selector implements it.
+type TargetSelector interface {
+	IncludesPod(namespace, pod string) bool
+	IncludesContainer(namespace, pod, container string) bool
 }
 
 // OutputConfig encapsulates configuration for controlling log output.
 type OutputConfig struct {
-	TeeDir string // Directory to write logs (optional).
+	TeeDir string // Directory to write logs to with a FileSink (optional).
 	Silent bool   // Suppress console log output.
+
+	// TeeOptions configures the handling of write failures and
+	// the disk budget of the TeeDir sink.
+	TeeOptions FileSinkOptions
+
+	// Processors filter and transform log records, in order,
+	// before they reach Callbacks.OnLogLine or any sink
+	// (optional).
+	Processors []Processor
+
+	// Buffer decouples reading each container's logs from
+	// writing them (optional; nil writes each record from the
+	// goroutine streaming the container before reading on).
+	Buffer *BufferPolicy
+
+	// Sinks receive every log record, after Callbacks.OnLogLine
+	// and the TeeDir sink (optional). Those that implement
+	// io.Closer are closed by StopStreaming.
+	Sinks []Sink
+
+	// StopTimeout bounds how long StopStreaming waits for the
+	// records already read to be written before it drops those
+	// still buffered and closes the sinks, so that a stuck sink
+	// cannot hold up shutdown. Zero selects DefaultStopTimeout.
+	StopTimeout time.Duration
 }
 
-// New creates a new Kat instance.
-func New(clientset *kubernetes.Clientset, outputConfig *OutputConfig, callbacks *Callbacks) *Kat {
-	return &Kat{
+// DefaultStopTimeout is how long StopStreaming waits for the records
+// already read to be written when no timeout is configured.
+const DefaultStopTimeout = 10 * time.Second
+
+// New creates a new Kat instance. A nil streamConfig streams regular
+// containers only.
+func New(clientset kubernetes.Interface, streamConfig *StreamConfig, outputConfig *OutputConfig, callbacks *Callbacks) *Kat {
+	if streamConfig == nil {
+		streamConfig = &StreamConfig{}
+	}
+
+	if streamConfig.Retry == nil {
+		config := *streamConfig
+		config.Retry = DefaultRetryPolicy()
+		streamConfig = &config
+	}
+
+	k := &Kat{
 		clientset:    clientset,
+		streamConfig: streamConfig,
 		outputConfig: outputConfig,
 		callbacks:    callbacks,
+		owners:       newOwnerChains(streamConfig.Metadata),
+		scheduler:    newStreamScheduler(streamConfig.Admission, streamConfig.Throttle),
+		buffers:      make(map[*recordBuffer]struct{}),
+	}
+
+	if callbacks != nil && callbacks.OnLogLine != nil {
+		k.sinks = append(k.sinks, callbackSink{onLogLine: callbacks.OnLogLine})
+	}
+
+	if outputConfig != nil && outputConfig.TeeDir != "" {
+		k.sinks = append(k.sinks, NewFileSink(outputConfig.TeeDir, outputConfig.TeeOptions, callbacks))
+	}
+
+	if outputConfig != nil {
+		k.processors = outputConfig.Processors
+		k.buffer = outputConfig.Buffer
+		k.sinks = append(k.sinks, outputConfig.Sinks...)
+	}
+
+	if streamConfig.Throttle != nil {
+		streamConfig.Throttle.subscribe(k.reportThrottle)
+	}
+
+	return k
+}
+
+// reportThrottle reports a change to the request rate through the
+// callbacks.
+func (k *Kat) reportThrottle(event throttleEvent) {
+	if k.callbacks == nil {
+		return
+	}
+
+	if event.throttled && k.callbacks.OnThrottled != nil {
+		k.callbacks.OnThrottled(event.qps, event.retryAfter, event.fairness)
+	}
+
+	if !event.throttled && k.callbacks.OnThrottleRecovered != nil {
+		k.callbacks.OnThrottleRecovered(event.qps)
 	}
 }
 
 // StartStreaming begins streaming logs for the specified namespaces.
 func (k *Kat) StartStreaming(ctx context.Context, namespaces []string, since time.Duration) error {
+	if err := k.streamConfig.Validate(); err != nil {
+		return err
+	}
+
+	watches := make(map[string]*workloadSet, len(namespaces))
+	for _, namespace := range namespaces {
+		watches[namespace] = nil
+	}
+
+	return k.watchNamespaces(ctx, watches, since)
+}
+
+// StartStreamingAllNamespaces begins streaming logs for the pods
+// selected by Targets in every namespace, using a single
+// cluster-wide pod watch rather than one per namespace. This
+// requires permission to list and watch pods in all namespaces; if
+// that is denied, the error returned satisfies apierrors.IsForbidden
+// and nothing has been streamed, so the caller can fall back to
+// StartStreaming.
+func (k *Kat) StartStreamingAllNamespaces(ctx context.Context, since time.Duration) error {
+	if err := k.streamConfig.Validate(); err != nil {
+		return err
+	}
+
+	return k.watchPods(ctx, metav1.NamespaceAll, nil, since)
+}
+
+// StartStreamingWorkloads begins streaming logs for the pods of the
+// specified workloads. Only pods controlled by the workloads are
+// streamed, including pods created by later rollouts; other pods in
+// the same namespaces are ignored.
+func (k *Kat) StartStreamingWorkloads(ctx context.Context, workloads []Workload, since time.Duration) error {
+	if err := k.streamConfig.Validate(); err != nil {
+		return err
+	}
+
+	watches := make(map[string]*workloadSet)
+
+	for _, workload := range workloads {
+		if workload.Namespace == "" {
+			return fmt.Errorf("no namespace for %s", workload)
+		}
+
+		resolved, err := k.resolveWorkload(ctx, workload)
+		if err != nil {
+			return err
+		}
+
+		set := watches[workload.Namespace]
+		if set == nil {
+			set = &workloadSet{k: k}
+			watches[workload.Namespace] = set
+		}
+
+		set.workloads = append(set.workloads, resolved)
+	}
+
+	return k.watchNamespaces(ctx, watches, since)
+}
+
+// watchNamespaces watches pods in each namespace, restricted to the
+// namespace's workloads if it has any, until ctx is done.
+func (k *Kat) watchNamespaces(ctx context.Context, watches map[string]*workloadSet, since time.Duration) error {
 	var wg sync.WaitGroup
 
-	errCh := make(chan error, len(namespaces))
+	errCh := make(chan error, len(watches))
 
-	for _, namespace := range namespaces {
+	for namespace, workloads := range watches {
 		wg.Add(1)
 
-		go func(namespace string) {
+		go func(namespace string, workloads *workloadSet) {
 			defer wg.Done()
 
-			if err := k.watchPods(ctx, namespace, since); err != nil {
+			if err := k.watchPods(ctx, namespace, workloads, since); err != nil {
 				errCh <- fmt.Errorf("namespace %s: %w", namespace, err)
 			}
-		}(namespace)
+		}(namespace, workloads)
 	}
 
 	wg.Wait()
@@ -95,13 +522,31 @@ func (k *Kat) StartStreaming(ctx context.Context, namespaces []string, since tim
 	return nil
 }
 
-// StopStreaming stops all active log streams and closes open files.
+// StreamStats returns the number of containers being streamed, the
+// number waiting to be admitted under the admission policy, and the
+// number of records dropped from full output buffers.
+func (k *Kat) StreamStats() StreamStats {
+	stats := k.scheduler.stats()
+	stats.Dropped = k.dropped.Load()
+
+	return stats
+}
+
+// StopStreaming stops all active log streams and closes the sinks
+// once the records already read have been written to them. If they
+// have not been written within the stop timeout, because a sink is
+// stuck, the records still buffered are dropped and counted, and the
+// sinks are closed regardless.
 func (k *Kat) StopStreaming() error {
 	var errs []error
 
+	k.stopMu.Lock()
+	k.stopped = true
+	k.stopMu.Unlock()
+
 	k.activeStreams.Range(func(key, value any) bool {
-		if cancel, ok := value.(context.CancelFunc); ok {
-			cancel()
+		if stream, ok := value.(*podStream); ok {
+			stream.cancel()
 		}
 
 		k.activeStreams.Delete(key)
@@ -109,25 +554,17 @@ func (k *Kat) StopStreaming() error {
 		return true
 	})
 
-	k.openFiles.Range(func(key, value any) bool {
-		if file, ok := value.(*os.File); ok {
-			if err := file.Sync(); err != nil {
-				errs = append(errs, fmt.Errorf("sync file %v: %w", key, err))
-			}
-
-			if err := file.Close(); err != nil {
-				errs = append(errs, fmt.Errorf("close file %v: %w", key, err))
-			}
+	if err := k.drain(); err != nil {
+		errs = append(errs, err)
+	}
 
-			if k.callbacks != nil && k.callbacks.OnFileClosed != nil {
-				k.callbacks.OnFileClosed(key.(string))
+	for _, sink := range k.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
 			}
 		}
-
-		k.openFiles.Delete(key)
-
-		return true
-	})
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("errors during cleanup: %v", errs)
@@ -136,48 +573,133 @@ func (k *Kat) StopStreaming() error {
 	return nil
 }
 
-func (k *Kat) watchPods(ctx context.Context, namespace string, since time.Duration) error {
-	podList, err := k.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("error listing pods in namespace %s: %w", namespace, err)
+// drain waits for the container streams to end and their buffered
+// records to be written, for up to the stop timeout. Past it, the
+// buffers are abandoned and the writers still running get a moment
+// to mark the records dropped before drain gives up on them.
+func (k *Kat) drain() error {
+	timeout := DefaultStopTimeout
+	if k.outputConfig != nil && k.outputConfig.StopTimeout > 0 {
+		timeout = k.outputConfig.StopTimeout
 	}
 
-	for _, pod := range podList.Items {
-		if pod.Status.Phase == corev1.PodRunning {
-			k.startLogStream(ctx, namespace, pod.Name, since)
-		}
+	done := make(chan struct{})
+
+	go func() {
+		k.streams.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	k.stopMu.Lock()
+
+	dropped := 0
+	for buffer := range k.buffers {
+		dropped += buffer.abandon()
+	}
+
+	k.stopMu.Unlock()
+
+	k.dropped.Add(int64(dropped))
+
+	select {
+	case <-done:
+	case <-time.After(timeout / 10):
 	}
 
-	factory := informers.NewSharedInformerFactoryWithOptions(k.clientset, 0, informers.WithNamespace(namespace))
+	return fmt.Errorf("gave up after %s waiting for output to be written, dropping %s still buffered", timeout, countedLines(dropped))
+}
+
+// watchPods streams the selected pods in namespace, or in all
+// namespaces if it is metav1.NamespaceAll, until ctx is done.
+func (k *Kat) watchPods(ctx context.Context, namespace string, workloads *workloadSet, since time.Duration) error {
+	// Containers that start from here on are streamed from their
+	// first line; those already running are streamed from this
+	// point in time.
+	sinceTime := time.Now().Add(-since)
+
+	selectPods := func(options *metav1.ListOptions) {
+		k.selectPods(options)
+		workloads.selectPods(options)
+	}
+
+	// Check that pods can be listed before watching, as the
+	// informer would otherwise retry a forbidden list forever.
+	// The pods themselves are delivered by the informer.
+	listOptions := metav1.ListOptions{Limit: 1}
+	selectPods(&listOptions)
+
+	if _, err := k.clientset.CoreV1().Pods(namespace).List(ctx, listOptions); err != nil {
+		return fmt.Errorf("error listing pods in %s: %w", namespaceScope(namespace), err)
+	}
+
+	// Pods whose owners could not be looked up are decided again
+	// after a backoff, as the informer may not deliver them again.
+	retries := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[types.NamespacedName]())
+	defer retries.ShutDown()
+
+	factory := informers.NewSharedInformerFactoryWithOptions(k.clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(selectPods),
+		informers.WithTransform(transformPod),
+	)
 	podInformer := factory.Core().V1().Pods().Informer()
+	pods := factory.Core().V1().Pods().Lister()
 
 	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj any) {
 			pod := obj.(*corev1.Pod)
-			if pod.Status.Phase == corev1.PodRunning {
-				k.startLogStream(ctx, namespace, pod.Name, since)
+			if len(k.streamableContainers(pod, sinceTime)) > 0 {
+				if included, _ := k.podIncluded(ctx, workloads, retries, pod); included {
+					k.startLogStream(ctx, pods, pod, sinceTime)
+				}
 			}
 		},
 		UpdateFunc: func(oldObj, newObj any) {
 			oldPod := oldObj.(*corev1.Pod)
 			newPod := newObj.(*corev1.Pod)
 
-			if newPod.Status.Phase == corev1.PodRunning && oldPod.Status.Phase != corev1.PodRunning {
-				k.startLogStream(ctx, namespace, newPod.Name, since)
-			} else if newPod.Status.Phase != corev1.PodRunning {
-				k.stopLogStream(newPod.Name)
+			// Streams are never stopped here: a terminated
+			// container's stream runs to EOF on its own so
+			// that its final output is not lost.
+			if k.hasNewContainerInstances(oldPod, newPod, sinceTime) || (podFinished(newPod) && !podFinished(oldPod)) {
+				if included, _ := k.podIncluded(ctx, workloads, retries, newPod); included {
+					k.startLogStream(ctx, pods, newPod, sinceTime)
+				}
 			}
 		},
 		DeleteFunc: func(obj any) {
-			pod := obj.(*corev1.Pod)
-			k.stopLogStream(pod.Name)
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			if pod, ok := obj.(*corev1.Pod); ok {
+				k.stopLogStream(podStreamKey(pod))
+			}
 		},
 	})
 
 	go podInformer.Run(ctx.Done())
+	go k.retryPods(ctx, pods, workloads, retries, sinceTime)
 
 	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
-		return fmt.Errorf("failed to sync informer cache for namespace %s", namespace)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return fmt.Errorf("failed to sync informer cache for %s", namespaceScope(namespace))
+	}
+
+	err := workloads.watchServices(ctx, namespace, func() {
+		k.reevaluatePods(ctx, pods, workloads, retries, sinceTime)
+	})
+	if err != nil {
+		return err
 	}
 
 	<-ctx.Done()
@@ -185,138 +707,662 @@ func (k *Kat) watchPods(ctx context.Context, namespace string, since time.Durati
 	return nil
 }
 
-func (k *Kat) startLogStream(ctx context.Context, namespace, podName string, since time.Duration) {
-	if _, exists := k.activeStreams.Load(podName); exists {
-		return
+// namespaceScope describes the namespaces watched by a pod watch in
+// namespace, which may be metav1.NamespaceAll.
+func namespaceScope(namespace string) string {
+	if namespace == metav1.NamespaceAll {
+		return "all namespaces"
 	}
 
-	podCtx, cancel := context.WithCancel(ctx)
-	k.activeStreams.Store(podName, cancel)
+	return "namespace " + namespace
+}
+
+// reevaluatePods starts streaming the watched pods that have come
+// to belong to the workloads and stops streaming those that no longer
+// do.
+func (k *Kat) reevaluatePods(ctx context.Context, pods corev1listers.PodLister, workloads *workloadSet, retries podRetries, sinceTime time.Time) {
+	podList, _ := pods.List(labels.Everything())
 
-	go func() {
-		defer func() {
-			cancel()
-			k.activeStreams.Delete(podName)
-		}()
+	for _, pod := range podList {
+		included, decided := k.podIncluded(ctx, workloads, retries, pod)
+		if !decided {
+			continue
+		}
 
-		backoff := wait.Backoff{
-			Steps:    5,
-			Duration: 100 * time.Millisecond,
-			Factor:   2.0,
-			Jitter:   0.1,
+		if !included {
+			k.stopLogStream(podStreamKey(pod))
+			continue
 		}
 
-		_ = wait.ExponentialBackoff(backoff, func() (bool, error) {
-			if err := k.streamPodLogs(podCtx, namespace, podName, since); err != nil {
-				return false, err
-			}
+		// A finished pod's stream may have been forgotten after
+		// its logs were streamed; they are not streamed again.
+		if podFinished(pod) {
+			continue
+		}
 
-			return true, nil
-		})
-	}()
+		if len(k.streamableContainers(pod, sinceTime)) > 0 {
+			k.startLogStream(ctx, pods, pod, sinceTime)
+		}
+	}
 }
 
-func (k *Kat) streamPodLogs(ctx context.Context, namespace, podName string, since time.Duration) error {
-	pod, err := k.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+// podRetries queues pods whose owners could not be looked up.
+type podRetries = workqueue.TypedRateLimitingInterface[types.NamespacedName]
+
+// podIncluded reports whether the pod belongs to the workloads, and
+// whether that could be decided. If the pod's owners could not be
+// looked up, the error is reported and the pod queued in retries.
+func (k *Kat) podIncluded(ctx context.Context, workloads *workloadSet, retries podRetries, pod *corev1.Pod) (included, decided bool) {
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+
+	included, err := workloads.includes(ctx, pod)
 	if err != nil {
-		return fmt.Errorf("error getting pod %s: %w", podName, err)
+		if k.callbacks != nil && k.callbacks.OnError != nil {
+			k.callbacks.OnError(err)
+		}
+
+		retries.AddRateLimited(key)
+
+		return false, false
 	}
 
-	var wg sync.WaitGroup
-	for _, container := range pod.Spec.Containers {
-		wg.Add(1)
+	retries.Forget(key)
 
-		go func(containerName string) {
-			defer wg.Done()
+	return included, true
+}
 
-			if k.callbacks != nil && k.callbacks.OnStreamStart != nil {
-				k.callbacks.OnStreamStart(namespace, podName, containerName)
-			}
+// retryPods decides again whether the pods queued in retries belong
+// to the workloads, starting to stream those that do, until the
+// queue is shut down.
+func (k *Kat) retryPods(ctx context.Context, pods corev1listers.PodLister, workloads *workloadSet, retries podRetries, sinceTime time.Time) {
+	for {
+		key, shutdown := retries.Get()
+		if shutdown {
+			return
+		}
+
+		pod, err := pods.Pods(key.Namespace).Get(key.Name)
+		if err != nil {
+			// The pod has gone.
+			retries.Forget(key)
+		} else if included, _ := k.podIncluded(ctx, workloads, retries, pod); included && len(k.streamableContainers(pod, sinceTime)) > 0 {
+			k.startLogStream(ctx, pods, pod, sinceTime)
+		}
+
+		retries.Done(key)
+	}
+}
+
+// selectPods restricts pod list and watch requests to the pods
+// matching the configured selectors. Pods that stop matching are
+// reported as deleted by the informer and their streams stopped.
+func (k *Kat) selectPods(options *metav1.ListOptions) {
+	options.LabelSelector = k.streamConfig.LabelSelector
+	options.FieldSelector = k.streamConfig.FieldSelector
+}
+
+// Validate reports whether the configuration is usable.
+// StartStreaming validates the configuration before streaming.
+func (c *StreamConfig) Validate() error {
+	if _, err := labels.Parse(c.LabelSelector); err != nil {
+		return fmt.Errorf("invalid label selector %q: %w", c.LabelSelector, err)
+	}
+
+	if _, err := fields.ParseSelector(c.FieldSelector); err != nil {
+		return fmt.Errorf("invalid field selector %q: %w", c.FieldSelector, err)
+	}
 
-			req := k.clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
-				Container: containerName,
-				Follow:    true,
-				SinceTime: &metav1.Time{Time: time.Now().Add(-since)},
+	return nil
+}
+
+// startLogStream starts streaming the pod, whose state is looked up
+// in pods from then on. If the pod is already being streamed, its
+// stream picks up any container instances that have started since.
+func (k *Kat) startLogStream(ctx context.Context, pods corev1listers.PodLister, pod *corev1.Pod, sinceTime time.Time) {
+	key := podStreamKey(pod)
+	stream := newPodStream(ctx, pods)
+
+	if existing, loaded := k.activeStreams.LoadOrStore(key, stream); loaded {
+		stream.cancel()
+		stream = existing.(*podStream)
+	}
+
+	k.streamPodLogs(stream, pod, sinceTime)
+}
+
+// streamPodLogs reconciles the pod's container streams with its
+// current container statuses: newly started containers get a
+// stream, and existing streams learn of restarts and of the pod
+// finishing.
+func (k *Kat) streamPodLogs(stream *podStream, pod *corev1.Pod, sinceTime time.Time) {
+	key := podStreamKey(pod)
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	for _, status := range k.streamableContainers(pod, sinceTime) {
+		cs, exists := stream.containers[status.Name]
+		if !exists {
+			cs = newContainerStream(pod, status.Name, stream.pods)
+			stream.containers[status.Name] = cs
+			stream.running++
+
+			k.spawn(func() {
+				k.streamContainer(stream.ctx, cs, sinceTime)
+				k.containerStreamEnded(key, stream)
 			})
+		}
+
+		cs.observe(status)
+	}
+
+	if podFinished(pod) && !stream.finished {
+		stream.finished = true
+
+		for _, cs := range stream.containers {
+			cs.finish()
+		}
+
+		if stream.running == 0 {
+			k.forgetLogStream(key, stream)
+		}
+	}
+}
+
+// containerStreamEnded records that one of the pod's container
+// streams has ended, forgetting the pod's stream if it was the last
+// of a finished pod.
+func (k *Kat) containerStreamEnded(key streamKey, stream *podStream) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.running--
+
+	if stream.finished && stream.running == 0 {
+		k.forgetLogStream(key, stream)
+	}
+}
 
-			stream, err := req.Stream(ctx)
+// forgetLogStream removes a pod's stream once it is over, unless it
+// has been replaced.
+func (k *Kat) forgetLogStream(key streamKey, stream *podStream) {
+	k.activeStreams.CompareAndDelete(key, stream)
+	stream.cancel()
+}
+
+// spawn runs f in a goroutine that StopStreaming waits for, unless
+// streaming has been stopped.
+func (k *Kat) spawn(f func()) {
+	k.stopMu.Lock()
+	defer k.stopMu.Unlock()
+
+	if k.stopped {
+		return
+	}
+
+	k.streams.Add(1)
+
+	go func() {
+		defer k.streams.Done()
+		f()
+	}()
+}
+
+// streamContainer streams each instance of a container in turn
+// until the pod finishes or the stream is cancelled. When a newer
+// instance starts, the output of the instance it replaced is
+// fetched first, so that lines printed by a crashing container
+// after its stream was lost, or before it was ever attached, are
+// not missed. Instances are streamed once the scheduler admits them.
+func (k *Kat) streamContainer(ctx context.Context, cs *containerStream, sinceTime time.Time) {
+	if k.buffer != nil {
+		defer k.startWriter(cs)()
+	} else {
+		defer k.closeOutputs(cs)
+	}
+
+	streamed := int32(-1)
+	cursor := &logCursor{} // Position reached in the instance last streamed.
+
+	for {
+		restartCount, previousFinishedAt, finished := cs.state()
+
+		if restartCount > streamed {
+			release, err := k.scheduler.acquire(ctx, cs.admissionPriority())
 			if err != nil {
+				return
+			}
+
+			// The container may have restarted while the
+			// stream waited to be admitted.
+			restartCount, previousFinishedAt, _ = cs.state()
+
+			switch previous := restartCount - 1; {
+			case streamed >= 0 && previous == streamed:
+				k.streamPreviousLogs(ctx, cs, previous, cursor, sinceTime)
+			case streamed >= 0 && previous > streamed:
+				// Instances came and went between
+				// status updates.
+				k.reportGap(cs, cursor.since(sinceTime))
+				k.streamPreviousLogs(ctx, cs, previous, &logCursor{}, sinceTime)
+			case previous >= 0 && previousFinishedAt.After(sinceTime):
+				k.streamPreviousLogs(ctx, cs, previous, &logCursor{}, sinceTime)
+			}
+
+			streamed = restartCount
+			cursor = k.streamContainerLogs(ctx, cs, restartCount, sinceTime)
+			release()
+
+			continue
+		}
+
+		if finished {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cs.wake:
+		}
+	}
+}
+
+// streamContainerLogs follows an instance of a container until it
+// exits. The kubelet ends the stream with EOF when the instance
+// exits, but a dropped connection can look the same, so the
+// instance's status is checked before the stream is considered
+// complete. Interrupted streams are reconnected from where they left
+// off, according to the retry policy, for as long as the instance
+// remains current. It returns the position reached.
+func (k *Kat) streamContainerLogs(ctx context.Context, cs *containerStream, instance int32, sinceTime time.Time) *logCursor {
+	if k.callbacks != nil && k.callbacks.OnStreamStart != nil {
+		k.callbacks.OnStreamStart(cs.namespace, cs.podName, cs.name)
+	}
+
+	defer func() {
+		if k.callbacks != nil && k.callbacks.OnStreamStop != nil {
+			k.callbacks.OnStreamStop(cs.namespace, cs.podName, cs.name)
+		}
+	}()
+
+	policy := k.streamConfig.Retry
+	cursor := &logCursor{}
+
+	for attempt := 0; ; {
+		requestCtx, cancelRequest := context.WithCancel(ctx)
+		watchdog := k.startWatchdog(cs, cancelRequest)
+
+		stream, err := k.openLogs(requestCtx, cs, &corev1.PodLogOptions{
+			Container: cs.name,
+			Follow:    true,
+			SinceTime: &metav1.Time{Time: cursor.since(sinceTime)},
+		})
+		if err == nil {
+			if attempt > 0 && k.callbacks != nil && k.callbacks.OnStreamReconnect != nil {
+				k.callbacks.OnStreamReconnect(cs.namespace, cs.podName, cs.name, attempt)
+			}
+
+			var copied int
+
+			copied, err = k.copyLines(cs, instance, watchdog.reader(stream), cursor)
+			stream.Close()
+
+			if copied > 0 {
+				attempt = 0
+			}
+		}
+
+		stalled := watchdog.stop()
+		cancelRequest()
+
+		if ctx.Err() != nil {
+			return cursor
+		}
+
+		if stalled {
+			err = errStreamStalled
+		}
+
+		if err == nil {
+			running, checkErr := cs.instanceRunning(instance)
+			if checkErr == nil && !running {
+				return cursor
+			}
+
+			err = checkErr
+			if err == nil {
+				err = io.EOF
+			}
+		}
+
+		// Once a newer instance has started, what remains of
+		// this one is fetched as the previous instance.
+		if restartCount, _, _ := cs.state(); restartCount != instance {
+			return cursor
+		}
+
+		// A stalled request is re-established straight away once
+		// the pod has been seen to be reachable. If the instance
+		// has terminated meanwhile, the new request drains what is
+		// left of its log.
+		if errors.Is(err, errStreamStalled) {
+			_, checkErr := cs.instanceRunning(instance)
+			if checkErr == nil {
+				continue
+			}
+
+			err = checkErr
+		}
+
+		attempt++
+
+		class := classifyError(err)
+		if !class.retryable() || !policy.allows(attempt) {
+			if class != errorNotFound {
 				if k.callbacks != nil && k.callbacks.OnError != nil {
-					k.callbacks.OnError(fmt.Errorf("error streaming logs for pod %s, container %s: %w", podName, containerName, err))
+					k.callbacks.OnError(fmt.Errorf("giving up streaming logs for pod %s, container %s (%s): %w", cs.podName, cs.name, class, err))
 				}
 
-				return
+				k.reportGap(cs, cursor.since(sinceTime))
 			}
-			defer stream.Close()
 
-			var (
-				file     *os.File
-				filePath string
-			)
+			return cursor
+		}
 
-			scanner := bufio.NewScanner(stream)
-			for scanner.Scan() {
-				line := scanner.Text()
+		delay := policy.delay(attempt)
 
-				if file == nil && k.outputConfig.TeeDir != "" {
-					filePath = filepath.Join(k.outputConfig.TeeDir, namespace, podName, containerName+".txt")
-					if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
-						if k.callbacks != nil && k.callbacks.OnError != nil {
-							k.callbacks.OnError(fmt.Errorf("error creating directories for %s: %w", filePath, err))
-						}
+		if k.callbacks != nil && k.callbacks.OnStreamRetry != nil {
+			k.callbacks.OnStreamRetry(cs.namespace, cs.podName, cs.name, attempt, delay, err)
+		}
 
-						return
-					}
+		select {
+		case <-ctx.Done():
+			return cursor
+		case <-time.After(delay):
+		}
+	}
+}
 
-					file, err = os.Create(filePath)
-					if err != nil {
-						if k.callbacks != nil && k.callbacks.OnError != nil {
-							k.callbacks.OnError(fmt.Errorf("error creating file %s: %w", filePath, err))
-						}
+// instanceRunning reports whether the given instance of a container
+// is still running, according to the informer cache.
+func (cs *containerStream) instanceRunning(instance int32) (bool, error) {
+	pod, err := cs.pods.Pods(cs.namespace).Get(cs.podName)
+	if err != nil {
+		return false, err
+	}
 
-						return
-					}
+	if pod.UID != cs.podUID {
+		return false, nil
+	}
 
-					k.openFiles.Store(filePath, file)
+	for _, statuses := range [][]corev1.ContainerStatus{
+		pod.Status.InitContainerStatuses,
+		pod.Status.ContainerStatuses,
+		pod.Status.EphemeralContainerStatuses,
+	} {
+		for _, status := range statuses {
+			if status.Name == cs.name {
+				return status.RestartCount == instance && status.State.Running != nil, nil
+			}
+		}
+	}
 
-					if k.callbacks != nil && k.callbacks.OnFileCreated != nil {
-						k.callbacks.OnFileCreated(filePath)
-					}
-				}
+	return false, nil
+}
+
+// streamPreviousLogs fetches the logs of the terminated instance
+// that preceded the current one, resuming from the position reached
+// while following it.
+func (k *Kat) streamPreviousLogs(ctx context.Context, cs *containerStream, instance int32, cursor *logCursor, sinceTime time.Time) {
+	stream, err := k.openLogs(ctx, cs, &corev1.PodLogOptions{
+		Container: cs.name,
+		Previous:  true,
+		SinceTime: &metav1.Time{Time: cursor.since(sinceTime)},
+	})
+	if err == nil {
+		_, err = k.copyLines(cs, instance, stream, cursor)
+		stream.Close()
+	}
+
+	if err != nil && ctx.Err() == nil {
+		if k.callbacks != nil && k.callbacks.OnError != nil {
+			k.callbacks.OnError(fmt.Errorf("error fetching previous logs for pod %s, container %s: %w", cs.podName, cs.name, err))
+		}
 
-				if k.callbacks != nil && k.callbacks.OnLogLine != nil {
-					k.callbacks.OnLogLine(namespace, podName, containerName, line)
+		k.reportGap(cs, cursor.since(sinceTime))
+	}
+}
+
+// openLogs issues a log request with timestamps enabled.
+func (k *Kat) openLogs(ctx context.Context, cs *containerStream, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	opts.Timestamps = true
+
+	return k.clientset.CoreV1().Pods(cs.namespace).GetLogs(cs.podName, opts).Stream(ctx)
+}
+
+// copyLines copies timestamped log lines past the cursor to the
+// sinks and the instance's tee file as log records.
+// Lines longer than the maximum line length are split or truncated
+// according to the stream configuration. It returns the number of
+// lines delivered.
+func (k *Kat) copyLines(cs *containerStream, instance int32, r io.Reader, cursor *logCursor) (int, error) {
+	copied := 0
+	maxLength := k.streamConfig.maxLineLength()
+
+	cursor.begin()
+
+	var (
+		continuing bool      // The chunk continues the previous one.
+		accepted   bool      // The current line is past the cursor.
+		length     int       // Length of the current line so far.
+		timestamp  time.Time // Timestamp of the current line.
+	)
+
+	lines := newLineReader(r, maxLength)
+	for {
+		chunk, more, err := lines.next()
+		if chunk != "" || err == nil {
+			if !continuing {
+				var gap bool
+
+				timestamp, chunk = splitTimestamp(chunk)
+
+				// Lines were lost after the last line
+				// received, which accepting this one
+				// moves the cursor past.
+				last := cursor.timestamp
+
+				accepted, gap = cursor.accept(timestamp)
+				if accepted && gap {
+					k.reportGap(cs, last)
 				}
 
-				if file != nil {
-					// TODO: handle write failures.
-					file.WriteString(line + "\n")
+				length = 0
+			}
+
+			length += len(chunk)
+
+			if accepted && (!continuing || k.streamConfig.LongLines == LongLineSplit) {
+				record := cs.record(instance, timestamp, chunk)
+
+				switch {
+				case more && k.streamConfig.LongLines == LongLineSplit:
+					record.Continued = true
+				case more:
+					record.Truncated = true
 				}
+
+				k.deliverRecord(cs, record)
+				copied++
+			}
+
+			if continuing && !more && accepted && k.callbacks != nil && k.callbacks.OnLongLine != nil {
+				k.callbacks.OnLongLine(cs.namespace, cs.podName, cs.name, length)
 			}
 
-			if file != nil {
-				k.openFiles.Delete(filePath)
-				file.Close()
+			continuing = more
+		}
+
+		if err == io.EOF {
+			return copied, nil
+		}
+
+		if err != nil {
+			return copied, err
+		}
+	}
+}
+
+// deliverRecord passes a record through the processors to the
+// sinks, or queues it for the writing goroutine to.
+func (k *Kat) deliverRecord(cs *containerStream, record LogRecord) {
+	cs.lastLine.Store(record.Received.UnixNano())
+	cs.quiet.Store(false)
+
+	if cs.buffer != nil {
+		if dropped := cs.buffer.push(record); dropped > 0 {
+			k.dropped.Add(int64(dropped))
+		}
+
+		return
+	}
+
+	k.process(cs, 0, record)
+}
+
+// startWriter starts a goroutine writing the records the container's
+// stream queues in its buffer, marking where records were dropped.
+// It returns a function that ends the buffer and waits for the
+// records queued to be written and the sinks closed.
+func (k *Kat) startWriter(cs *containerStream) (stop func()) {
+	cs.buffer = newRecordBuffer(k.buffer)
+	done := make(chan struct{})
 
-				if k.callbacks != nil && k.callbacks.OnFileClosed != nil {
-					k.callbacks.OnFileClosed(filePath)
+	k.stopMu.Lock()
+	k.buffers[cs.buffer] = struct{}{}
+	k.stopMu.Unlock()
+
+	go func() {
+		defer close(done)
+		defer k.closeOutputs(cs)
+
+		for {
+			next, ok := cs.buffer.take()
+			if next.dropped > 0 && (ok || cs.outputOpen) {
+				stream := next.record.StreamInfo
+				if !ok {
+					stream = cs.output
 				}
+
+				k.writeRecord(cs, droppedRecord(stream, next.dropped))
 			}
 
-			if k.callbacks != nil && k.callbacks.OnStreamStop != nil {
-				k.callbacks.OnStreamStop(namespace, podName, containerName)
+			if !ok {
+				return
 			}
-		}(container.Name)
+
+			k.process(cs, 0, next.record)
+		}
+	}()
+
+	return func() {
+		cs.buffer.close()
+		<-done
+
+		k.stopMu.Lock()
+		delete(k.buffers, cs.buffer)
+		k.stopMu.Unlock()
 	}
+}
 
-	wg.Wait()
+// process passes a record to the processor at stage, and the records
+// it emits on to the next stage, or to the sinks after the last.
+func (k *Kat) process(cs *containerStream, stage int, record LogRecord) {
+	if stage == len(k.processors) {
+		k.writeRecord(cs, record)
+		return
+	}
 
-	return nil
+	err := k.processors[stage].Process(record, func(record LogRecord) {
+		k.process(cs, stage+1, record)
+	})
+	if err != nil && k.callbacks != nil && k.callbacks.OnError != nil {
+		k.callbacks.OnError(fmt.Errorf("error processing logs for pod %s, container %s: %w", cs.podName, cs.name, err))
+	}
+}
+
+// writeRecord writes a record to the sinks, opening them for the
+// record's instance first if need be.
+func (k *Kat) writeRecord(cs *containerStream, record LogRecord) {
+	if !cs.outputOpen || cs.output.Instance != record.Instance {
+		k.closeOutputs(cs)
+		k.openOutputs(cs, record.StreamInfo)
+	}
+
+	for _, sink := range cs.outputs {
+		if err := sink.WriteRecord(record); err != nil {
+			k.reportSinkError(cs, err)
+		}
+	}
+}
+
+// openOutputs opens the sinks for the records of an instance of the
+// container. Sinks that fail to open are reported and skipped until
+// the next instance.
+func (k *Kat) openOutputs(cs *containerStream, stream StreamInfo) {
+	cs.outputs = cs.outputs[:0]
+
+	for _, sink := range k.sinks {
+		if streamSink, ok := sink.(StreamSink); ok {
+			if err := streamSink.OpenStream(stream); err != nil {
+				k.reportSinkError(cs, err)
+				continue
+			}
+		}
+
+		cs.outputs = append(cs.outputs, sink)
+	}
+
+	cs.output = stream
+	cs.outputOpen = true
+}
+
+// closeOutputs closes the sinks opened for the container's current
+// instance, if any.
+func (k *Kat) closeOutputs(cs *containerStream) {
+	if !cs.outputOpen {
+		return
+	}
+
+	for _, sink := range cs.outputs {
+		if streamSink, ok := sink.(StreamSink); ok {
+			if err := streamSink.CloseStream(cs.output); err != nil {
+				k.reportSinkError(cs, err)
+			}
+		}
+	}
+
+	cs.outputs = cs.outputs[:0]
+	cs.outputOpen = false
+}
+
+func (k *Kat) reportSinkError(cs *containerStream, err error) {
+	if k.callbacks != nil && k.callbacks.OnError != nil {
+		k.callbacks.OnError(fmt.Errorf("error writing logs for pod %s, container %s: %w", cs.podName, cs.name, err))
+	}
+}
+
+// reportGap reports that lines logged by a container after the
+// given time may have been lost.
+func (k *Kat) reportGap(cs *containerStream, after time.Time) {
+	if k.callbacks != nil && k.callbacks.OnLogGap != nil {
+		k.callbacks.OnLogGap(cs.namespace, cs.podName, cs.name, after)
+	}
 }
 
-func (k *Kat) stopLogStream(podName string) {
-	if cancel, ok := k.activeStreams.Load(podName); ok {
-		cancel.(context.CancelFunc)()
-		k.activeStreams.Delete(podName)
+func (k *Kat) stopLogStream(key streamKey) {
+	if stream, ok := k.activeStreams.LoadAndDelete(key); ok {
+		stream.(*podStream).cancel()
 	}
 }
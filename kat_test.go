@@ -0,0 +1,1087 @@
+package kat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frobware/kat/namespace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The fake clientset serves a fixed body for every log request.
+const fakeLogLine = "fake logs"
+
+// recorder collects callback invocations so tests can assert on them.
+type recorder struct {
+	mu         sync.Mutex
+	lines      []string
+	starts     []string
+	stops      []string
+	errs       []error
+	created    []string
+	closed     []string
+	gaps       []string
+	retries    []int
+	reconnects []int
+}
+
+func (r *recorder) callbacks() *Callbacks {
+	return &Callbacks{
+		OnError: func(err error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.errs = append(r.errs, err)
+		},
+		OnFileClosed: func(filePath string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.closed = append(r.closed, filePath)
+		},
+		OnFileCreated: func(filePath string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.created = append(r.created, filePath)
+		},
+		OnLogGap: func(namespace, podName, containerName string, _ time.Time) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.gaps = append(r.gaps, fmt.Sprintf("%s/%s:%s", namespace, podName, containerName))
+		},
+		OnLogLine: func(namespace, podName, containerName, line string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.lines = append(r.lines, fmt.Sprintf("%s/%s:%s %s", namespace, podName, containerName, line))
+		},
+		OnStreamStart: func(namespace, podName, containerName string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.starts = append(r.starts, fmt.Sprintf("%s/%s:%s", namespace, podName, containerName))
+		},
+		OnStreamStop: func(namespace, podName, containerName string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.stops = append(r.stops, fmt.Sprintf("%s/%s:%s", namespace, podName, containerName))
+		},
+		OnStreamRetry: func(_, _, _ string, attempt int, _ time.Duration, _ error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.retries = append(r.retries, attempt)
+		},
+		OnStreamReconnect: func(_, _, _ string, attempt int) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.reconnects = append(r.reconnects, attempt)
+		},
+	}
+}
+
+func (r *recorder) hasLine(line string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.lines, line)
+}
+
+func (r *recorder) hasStart(stream string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.starts, stream)
+}
+
+func (r *recorder) hasStop(stream string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.stops, stream)
+}
+
+func (r *recorder) startCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.starts)
+}
+
+func (r *recorder) stopCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.stops)
+}
+
+func (r *recorder) lineCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.lines)
+}
+
+// logRequests returns the options of every log request made
+// through the fake clientset.
+func logRequests(clientset *fake.Clientset) []*corev1.PodLogOptions {
+	var requests []*corev1.PodLogOptions
+
+	for _, action := range clientset.Actions() {
+		if action.GetSubresource() != "log" {
+			continue
+		}
+
+		if generic, ok := action.(k8stesting.GenericAction); ok {
+			if opts, ok := generic.GetValue().(*corev1.PodLogOptions); ok {
+				requests = append(requests, opts)
+			}
+		}
+	}
+
+	return requests
+}
+
+func newPod(namespace, name string, phase corev1.PodPhase, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID("uid-" + name),
+		},
+	}
+
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container})
+	}
+
+	setPodPhase(pod, phase)
+
+	return pod
+}
+
+// containerState returns a container state consistent with the pod
+// phase.
+func containerState(phase corev1.PodPhase) corev1.ContainerState {
+	switch phase {
+	case corev1.PodRunning:
+		return corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	case corev1.PodSucceeded, corev1.PodFailed:
+		return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.Now()}}
+	default:
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}
+	}
+}
+
+// setPodPhase sets the pod phase and the state of its regular
+// containers to match.
+func setPodPhase(pod *corev1.Pod, phase corev1.PodPhase) {
+	pod.Status.Phase = phase
+	pod.Status.ContainerStatuses = nil
+
+	for _, container := range pod.Spec.Containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  container.Name,
+			State: containerState(phase),
+		})
+	}
+}
+
+// newClientset returns a fake clientset and a channel that is
+// closed once the first pod watch has been established. Objects
+// created before that point are picked up by the informer's initial
+// list; objects created after it are delivered as watch events.
+func newClientset(objects ...runtime.Object) (*fake.Clientset, <-chan struct{}) {
+	clientset := fake.NewClientset(objects...)
+	return clientset, watchEstablished(clientset, "pods")
+}
+
+// watchEstablished returns a channel that is closed once the first
+// watch of resource has been established.
+func watchEstablished(clientset *fake.Clientset, resource string) <-chan struct{} {
+	watching := make(chan struct{})
+
+	var once sync.Once
+
+	clientset.PrependWatchReactor(resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+
+		once.Do(func() { close(watching) })
+
+		return true, w, nil
+	})
+
+	return watching
+}
+
+// noRetries disables reconnection. The fake clientset ends every
+// log stream straight away, which kat cannot tell apart from a
+// dropped connection to a running container.
+func noRetries() *RetryPolicy {
+	return &RetryPolicy{}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForChannel(t *testing.T, what string, ch <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// podLister returns a lister serving the given pods, standing in
+// for the informer cache of a pod watch.
+func podLister(t *testing.T, pods ...*corev1.Pod) corev1listers.PodLister {
+	t.Helper()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	return corev1listers.NewPodLister(indexer)
+}
+
+// failingPodLister is a pod lister whose lookups fail with err.
+type failingPodLister struct {
+	err error
+}
+
+func (l failingPodLister) List(labels.Selector) ([]*corev1.Pod, error)  { return nil, l.err }
+func (l failingPodLister) Get(string) (*corev1.Pod, error)              { return nil, l.err }
+func (l failingPodLister) Pods(string) corev1listers.PodNamespaceLister { return l }
+
+func hasActiveStream(k *Kat, key streamKey) bool {
+	_, ok := k.activeStreams.Load(key)
+	return ok
+}
+
+func TestStartStreaming_ExistingPods(t *testing.T) {
+	job := newPod("backend", "job-0", corev1.PodSucceeded, "job")
+	job.Status.ContainerStatuses[0].State.Terminated.FinishedAt = metav1.NewTime(time.Now().Add(-time.Hour))
+
+	clientset, _ := newClientset(
+		newPod("frontend", "web-0", corev1.PodRunning, "app", "sidecar"),
+		newPod("backend", "db-0", corev1.PodRunning, "db"),
+		job,
+	)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- k.StartStreaming(ctx, []string{"frontend", "backend"}, time.Minute)
+	}()
+
+	for _, line := range []string{
+		"frontend/web-0:app " + fakeLogLine,
+		"frontend/web-0:sidecar " + fakeLogLine,
+		"backend/db-0:db " + fakeLogLine,
+	} {
+		waitFor(t, line, func() bool { return rec.hasLine(line) })
+	}
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartStreaming did not return after context cancellation")
+	}
+
+	if rec.hasStart("backend/job-0:job") {
+		t.Errorf("expected no stream for a pod that completed before the since window")
+	}
+
+	if err := k.StopStreaming(); err != nil {
+		t.Errorf("unexpected error stopping streams: %v", err)
+	}
+}
+
+func TestStartStreaming_ListError(t *testing.T) {
+	clientset, _ := newClientset()
+	clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("boom")
+	})
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	err := k.StartStreaming(context.Background(), []string{"default"}, time.Minute)
+	if err == nil {
+		t.Fatal("expected error but got none")
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.errs) != 1 {
+		t.Errorf("expected 1 OnError call, got %d", len(rec.errs))
+	}
+}
+
+func TestWatchPods_PodLifecycle(t *testing.T) {
+	clientset, watching := newClientset()
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- k.watchPods(ctx, "default", nil, time.Minute)
+	}()
+
+	waitForChannel(t, "pod watch", watching)
+
+	pods := clientset.CoreV1().Pods("default")
+
+	// A pending pod must not be streamed.
+	pod := newPod("default", "web-0", corev1.PodPending, "app")
+	if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+
+	// Adding a running pod starts streaming.
+	if _, err := pods.Create(ctx, newPod("default", "web-1", corev1.PodRunning, "app"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+
+	waitFor(t, "web-1 log line", func() bool { return rec.hasLine("default/web-1:app " + fakeLogLine) })
+
+	if rec.hasStart("default/web-0:app") {
+		t.Errorf("expected no stream for pending pod")
+	}
+
+	// Transitioning to running starts streaming.
+	setPodPhase(pod, corev1.PodRunning)
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	waitFor(t, "web-0 log line", func() bool { return rec.hasLine("default/web-0:app " + fakeLogLine) })
+	waitFor(t, "web-0 stream stop", func() bool { return rec.hasStop("default/web-0:app") })
+
+	// Finishing does not cancel the stream, which drains to EOF
+	// on its own, and does not start it again.
+	starts := rec.startCount()
+	setPodPhase(pod, corev1.PodFailed)
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	// Deleting a pod stops its stream.
+	if err := pods.Delete(ctx, "web-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+
+	waitFor(t, "web-1 stream removal", func() bool { return !hasActiveStream(k, streamKey{"default", "uid-web-1"}) })
+
+	// A finished pod is forgotten once its containers' streams
+	// have ended, without waiting for it to be deleted.
+	waitFor(t, "web-0 stream removal", func() bool { return !hasActiveStream(k, streamKey{"default", "uid-web-0"}) })
+
+	if got := rec.startCount(); got != starts {
+		t.Errorf("expected %d stream starts, got %d", starts, got)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchPods did not return after context cancellation")
+	}
+}
+
+func TestStartLogStream_Deduplicates(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, _ := newClientset(pod)
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, nil)
+
+	key := podStreamKey(pod)
+	stream := newPodStream(context.Background(), podLister(t, pod))
+	k.activeStreams.Store(key, stream)
+
+	k.startLogStream(context.Background(), podLister(t, pod), pod, time.Now().Add(-time.Minute))
+
+	if existing, _ := k.activeStreams.Load(key); existing != stream {
+		t.Errorf("expected the active stream to be reused")
+	}
+
+	k.stopLogStream(key)
+
+	if stream.ctx.Err() == nil {
+		t.Errorf("expected stopLogStream to cancel the active stream")
+	}
+
+	if hasActiveStream(k, key) {
+		t.Errorf("expected stream to be removed")
+	}
+}
+
+func TestStopLogStream_KeyedByNamespaceAndUID(t *testing.T) {
+	clientset, _ := newClientset()
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, nil)
+
+	streams := map[streamKey]*podStream{}
+	keys := []streamKey{
+		{"frontend", "uid-a"},
+		{"backend", "uid-a"},
+		{"frontend", "uid-b"},
+	}
+
+	for _, key := range keys {
+		streams[key] = newPodStream(context.Background(), nil)
+		k.activeStreams.Store(key, streams[key])
+	}
+
+	k.stopLogStream(streamKey{"frontend", "uid-a"})
+
+	for _, key := range keys {
+		want := key == streamKey{"frontend", "uid-a"}
+		if cancelled := streams[key].ctx.Err() != nil; cancelled != want {
+			t.Errorf("stream %v: expected cancelled=%v, got %v", key, want, cancelled)
+		}
+
+		if hasActiveStream(k, key) == want {
+			t.Errorf("stream %v: expected active=%v", key, !want)
+		}
+	}
+}
+
+func TestStartStreaming_SamePodNameInDifferentNamespaces(t *testing.T) {
+	frontend := newPod("frontend", "web-0", corev1.PodRunning, "app")
+	backend := newPod("backend", "web-0", corev1.PodRunning, "app")
+	backend.UID = "uid-backend-web-0"
+
+	clientset, _ := newClientset(frontend, backend)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = k.StartStreaming(ctx, []string{"frontend", "backend"}, time.Minute)
+	}()
+
+	waitFor(t, "frontend log line", func() bool { return rec.hasLine("frontend/web-0:app " + fakeLogLine) })
+	waitFor(t, "backend log line", func() bool { return rec.hasLine("backend/web-0:app " + fakeLogLine) })
+}
+
+func TestInstanceRunning_RecreatedPod(t *testing.T) {
+	previous := newPod("default", "web-0", corev1.PodRunning, "app")
+	previous.UID = "uid-previous"
+
+	// A stream for the previous incarnation of web-0 must not
+	// follow its replacement.
+	cs := observedContainerStream(previous, "app", podLister(t, newPod("default", "web-0", corev1.PodRunning, "app")))
+
+	running, err := cs.instanceRunning(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if running {
+		t.Errorf("expected the container of a replaced pod not to be running")
+	}
+}
+
+func TestStreamPodLogs_Tee(t *testing.T) {
+	dir := t.TempDir()
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, _ := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{TeeDir: dir}, rec.callbacks())
+
+	stream := newPodStream(context.Background(), podLister(t, pod))
+	k.streamPodLogs(stream, pod, time.Now().Add(-time.Minute))
+
+	// The file stays open for further instances of the container
+	// until the stream ends.
+	waitFor(t, "stream stop", func() bool { return rec.hasStop("default/web-0:app") })
+	stream.cancel()
+
+	waitFor(t, "tee file to be closed", func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.closed) > 0
+	})
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.created) != 1 || len(rec.closed) != 1 || rec.created[0] != rec.closed[0] {
+		t.Fatalf("expected one file created and closed, got created=%v closed=%v", rec.created, rec.closed)
+	}
+
+	want := filepath.Join(dir, "default", "web-0", "app.0.txt")
+	if rec.created[0] != want {
+		t.Errorf("expected file %s, got %s", want, rec.created[0])
+	}
+
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatalf("failed to read tee file: %v", err)
+	}
+
+	if string(data) != fakeLogLine+"\n" {
+		t.Errorf("expected tee file content %q, got %q", fakeLogLine+"\n", string(data))
+	}
+}
+
+func TestWatchPods_ContainersStartIndependently(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "sidecar"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "sidecar",
+		State: containerState(corev1.PodPending),
+	}}
+
+	clientset, watching := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{InitContainers: true, EphemeralContainers: true, Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = k.watchPods(ctx, "default", nil, time.Minute)
+	}()
+
+	waitForChannel(t, "pod watch", watching)
+	waitFor(t, "app log line", func() bool { return rec.hasLine("default/web-0:app " + fakeLogLine) })
+
+	if rec.hasStart("default/web-0:sidecar") {
+		t.Errorf("expected no stream for a sidecar that has not started")
+	}
+
+	// The sidecar starts, then an ephemeral container is attached.
+	pod.Status.InitContainerStatuses[0].State = containerState(corev1.PodRunning)
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"},
+	}}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "debugger",
+		State: containerState(corev1.PodRunning),
+	}}
+
+	if _, err := clientset.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	waitFor(t, "sidecar log line", func() bool { return rec.hasLine("default/web-0:sidecar " + fakeLogLine) })
+	waitFor(t, "debugger log line", func() bool { return rec.hasLine("default/web-0:debugger " + fakeLogLine) })
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if n := len(rec.starts); n != 3 {
+		t.Errorf("expected each container to be streamed once, got %v", rec.starts)
+	}
+}
+
+func TestWatchPods_PendingPodWithRunningInitContainer(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodPending, "app")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "migrate"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "migrate",
+		State: containerState(corev1.PodRunning),
+	}}
+
+	clientset, _ := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{InitContainers: true, Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = k.watchPods(ctx, "default", nil, time.Minute)
+	}()
+
+	waitFor(t, "migrate log line", func() bool { return rec.hasLine("default/web-0:migrate " + fakeLogLine) })
+
+	if rec.hasStart("default/web-0:app") {
+		t.Errorf("expected no stream for a container that has not started")
+	}
+}
+
+func TestWatchPods_ContainerRestart(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, watching := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = k.watchPods(ctx, "default", nil, time.Minute)
+	}()
+
+	waitForChannel(t, "pod watch", watching)
+	waitFor(t, "first instance to be streamed", func() bool { return rec.hasStop("default/web-0:app") })
+
+	// An unrelated status change does not reattach.
+	pod.Status.Message = "unrelated"
+	if _, err := clientset.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	if _, err := clientset.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	waitFor(t, "second instance to be streamed", func() bool { return rec.startCount() == 2 })
+	waitFor(t, "second instance to finish", func() bool { return rec.stopCount() == 2 })
+
+	if n := rec.startCount(); n != 2 {
+		t.Errorf("expected one stream per container instance, got %d", n)
+	}
+
+	// The output of the first instance was fetched again once it
+	// was replaced. Deduplication relies on the kubelet's
+	// timestamps, which the fake clientset does not provide, so
+	// its line is delivered twice.
+	var previous []*corev1.PodLogOptions
+	for _, opts := range logRequests(clientset) {
+		if !opts.Timestamps {
+			t.Errorf("expected timestamps to be requested: %+v", opts)
+		}
+
+		if opts.Previous {
+			previous = append(previous, opts)
+		}
+	}
+
+	if len(previous) != 1 {
+		t.Fatalf("expected one request for the previous instance, got %d", len(previous))
+	}
+
+	if n := rec.lineCount(); n != 3 {
+		t.Errorf("expected 3 lines, got %d", n)
+	}
+}
+
+func TestWatchPods_RestartedBeforeAttach(t *testing.T) {
+	// The container crashed and restarted before kat attached:
+	// the terminated instance's output is recovered.
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	pod.Status.ContainerStatuses[0].RestartCount = 3
+	pod.Status.ContainerStatuses[0].LastTerminationState = containerState(corev1.PodFailed)
+
+	dir := t.TempDir()
+	clientset, _ := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{TeeDir: dir}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = k.watchPods(ctx, "default", nil, time.Minute)
+	}()
+
+	waitFor(t, "both instances to be streamed", func() bool { return rec.lineCount() == 2 })
+
+	for _, name := range []string{"app.2.txt", "app.3.txt"} {
+		data, err := os.ReadFile(filepath.Join(dir, "default", "web-0", name))
+		if err != nil {
+			t.Fatalf("failed to read tee file: %v", err)
+		}
+
+		if string(data) != fakeLogLine+"\n" {
+			t.Errorf("%s: expected content %q, got %q", name, fakeLogLine+"\n", string(data))
+		}
+	}
+}
+
+// observedContainerStream returns a stream for the named container,
+// looking the pod up in pods, that has observed the container's
+// current status.
+func observedContainerStream(pod *corev1.Pod, name string, pods corev1listers.PodLister) *containerStream {
+	cs := newContainerStream(pod, name, pods)
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == name {
+			cs.observe(status)
+		}
+	}
+
+	return cs
+}
+
+func TestStreamContainerLogs_ReconnectsRunningInstance(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, _ := newClientset(pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rec := &recorder{}
+	callbacks := rec.callbacks()
+	onRetry := callbacks.OnStreamRetry
+	callbacks.OnStreamRetry = func(namespace, podName, containerName string, attempt int, delay time.Duration, err error) {
+		onRetry(namespace, podName, containerName, attempt, delay, err)
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if len(rec.retries) == 3 {
+			cancel()
+		}
+	}
+
+	retry := &RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond}
+	k := New(clientset, &StreamConfig{Retry: retry}, &OutputConfig{}, callbacks)
+
+	// Each fake log stream ends while the container is still
+	// running, as a dropped connection would. As every stream
+	// delivers a line, the retry count never exceeds the limit.
+	k.streamContainerLogs(ctx, observedContainerStream(pod, "app", podLister(t, pod)), 0, time.Now().Add(-time.Minute))
+
+	if n := len(logRequests(clientset)); n != 3 {
+		t.Errorf("expected 3 log requests, got %d", n)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !slices.Equal(rec.retries, []int{1, 1, 1}) {
+		t.Errorf("expected retries [1 1 1], got %v", rec.retries)
+	}
+
+	if !slices.Equal(rec.reconnects, []int{1, 1}) {
+		t.Errorf("expected reconnects [1 1], got %v", rec.reconnects)
+	}
+
+	if len(rec.errs) != 0 {
+		t.Errorf("expected no errors, got %v", rec.errs)
+	}
+}
+
+func TestStreamContainerLogs_GivesUpOnPermanentError(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, _ := newClientset(pod)
+	pods := failingPodLister{apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "web-0", fmt.Errorf("denied"))}
+
+	rec := &recorder{}
+	k := New(clientset, nil, &OutputConfig{}, rec.callbacks())
+
+	k.streamContainerLogs(context.Background(), observedContainerStream(pod, "app", pods), 0, time.Now().Add(-time.Minute))
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.retries) != 0 {
+		t.Errorf("expected no retries, got %v", rec.retries)
+	}
+
+	if len(rec.errs) != 1 || len(rec.gaps) != 1 {
+		t.Errorf("expected giving up to be reported once, got errors=%v gaps=%v", rec.errs, rec.gaps)
+	}
+}
+
+func TestStreamContainerLogs_TerminatedInstance(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodSucceeded, "app")
+	clientset, _ := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, nil, &OutputConfig{}, rec.callbacks())
+
+	k.streamContainerLogs(context.Background(), observedContainerStream(pod, "app", podLister(t, pod)), 0, time.Now().Add(-time.Minute))
+
+	if n := len(logRequests(clientset)); n != 1 {
+		t.Errorf("expected a single log request, got %d", n)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.retries) != 0 || len(rec.errs) != 0 || len(rec.gaps) != 0 {
+		t.Errorf("expected the stream to complete, got retries=%v errors=%v gaps=%v", rec.retries, rec.errs, rec.gaps)
+	}
+}
+
+func TestStreamContainerLogs_DeletedPod(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	clientset, _ := newClientset()
+
+	rec := &recorder{}
+	k := New(clientset, nil, &OutputConfig{}, rec.callbacks())
+
+	k.streamContainerLogs(context.Background(), observedContainerStream(pod, "app", podLister(t)), 0, time.Now().Add(-time.Minute))
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.retries) != 0 || len(rec.errs) != 0 {
+		t.Errorf("expected the stream to end quietly, got retries=%v errors=%v", rec.retries, rec.errs)
+	}
+}
+
+func TestWatchPods_NoPodGets(t *testing.T) {
+	clientset, watching := newClientset(newPod("default", "web-0", corev1.PodRunning, "app"))
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreaming(ctx, []string{"default"}, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+
+	// The fake log stream ends while the container is running, so
+	// its status is checked before the stream gives up.
+	waitFor(t, "giving up", func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.errs) == 1
+	})
+
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "pods" && action.GetSubresource() != "log" {
+			t.Errorf("expected pods to be looked up in the informer cache, got a GET of %s", action.(k8stesting.GetAction).GetName())
+		}
+	}
+}
+
+func TestStartStreaming_Selectors(t *testing.T) {
+	web := newPod("default", "web-0", corev1.PodRunning, "app")
+	web.Labels = map[string]string{"app": "web", "tier": "frontend"}
+
+	cachePod := newPod("default", "cache-0", corev1.PodRunning, "app")
+	cachePod.Labels = map[string]string{"app": "web", "tier": "cache"}
+
+	clientset, watching := newClientset(web, cachePod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{
+		Retry:         noRetries(),
+		LabelSelector: "app=web,tier!=cache",
+		FieldSelector: "spec.nodeName=worker-3",
+	}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreaming(ctx, []string{"default"}, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+	waitFor(t, "web-0 stream", func() bool { return rec.hasLine("default/web-0:app " + fakeLogLine) })
+
+	if rec.hasStart("default/cache-0:app") {
+		t.Errorf("expected no stream for a pod excluded by the label selector")
+	}
+
+	for _, action := range clientset.Actions() {
+		var labelSelector, fieldSelector string
+
+		switch action := action.(type) {
+		case k8stesting.ListAction:
+			labelSelector = action.GetListRestrictions().Labels.String()
+			fieldSelector = action.GetListRestrictions().Fields.String()
+		case k8stesting.WatchAction:
+			labelSelector = action.GetWatchRestrictions().Labels.String()
+			fieldSelector = action.GetWatchRestrictions().Fields.String()
+		default:
+			continue
+		}
+
+		if labelSelector != "app=web,tier!=cache" {
+			t.Errorf("expected %s to use the label selector, got %q", action.GetVerb(), labelSelector)
+		}
+
+		if fieldSelector != "spec.nodeName=worker-3" {
+			t.Errorf("expected %s to use the field selector, got %q", action.GetVerb(), fieldSelector)
+		}
+	}
+}
+
+func TestStartStreaming_InvalidSelector(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *StreamConfig
+	}{
+		{name: "label selector", config: &StreamConfig{LabelSelector: "app==="}},
+		{name: "field selector", config: &StreamConfig{FieldSelector: "spec.nodeName"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, _ := newClientset()
+			k := New(clientset, tt.config, &OutputConfig{}, nil)
+
+			if err := k.StartStreaming(context.Background(), []string{"default"}, time.Minute); err == nil {
+				t.Errorf("expected an error for an invalid %s", tt.name)
+			}
+
+			if n := len(clientset.Actions()); n != 0 {
+				t.Errorf("expected no requests, got %d", n)
+			}
+		})
+	}
+}
+
+func TestStartStreamingAllNamespaces(t *testing.T) {
+	clientset, watching := newClientset(
+		newPod("shop", "web-0", corev1.PodRunning, "app"),
+		newPod("kube-system", "dns-0", corev1.PodRunning, "dns"),
+	)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{
+		Retry:   noRetries(),
+		Targets: selector(t, nil, []string{"kube-*"}),
+	}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreamingAllNamespaces(ctx, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+	waitFor(t, "web-0 stream", func() bool { return rec.hasLine("shop/web-0:app " + fakeLogLine) })
+
+	// Pods in namespaces created after the watch started are
+	// picked up by the same watch.
+	if _, err := clientset.CoreV1().Pods("billing").Create(ctx, newPod("billing", "api-0", corev1.PodRunning, "api"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitFor(t, "api-0 stream", func() bool { return rec.hasLine("billing/api-0:api " + fakeLogLine) })
+
+	if rec.hasStart("kube-system/dns-0:dns") {
+		t.Errorf("expected no stream for a pod in an excluded namespace")
+	}
+
+	var lists, watches int
+
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource != "pods" || (action.GetVerb() != "list" && action.GetVerb() != "watch") {
+			continue
+		}
+
+		if action.GetNamespace() != metav1.NamespaceAll {
+			t.Errorf("expected %s in all namespaces, got namespace %q", action.GetVerb(), action.GetNamespace())
+		}
+
+		if action.GetVerb() == "list" {
+			lists++
+		} else {
+			watches++
+		}
+	}
+
+	if lists != 2 || watches != 1 {
+		t.Errorf("expected 2 lists and 1 watch of pods, got %d lists and %d watches", lists, watches)
+	}
+}
+
+func TestStartStreamingAllNamespaces_Forbidden(t *testing.T) {
+	clientset, _ := newClientset(newPod("shop", "web-0", corev1.PodRunning, "app"))
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != metav1.NamespaceAll {
+			return false, nil, nil
+		}
+
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", fmt.Errorf("cluster-wide access denied"))
+	})
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	err := k.StartStreamingAllNamespaces(context.Background(), time.Minute)
+	if !apierrors.IsForbidden(err) {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.errs) != 0 || len(rec.starts) != 0 {
+		t.Errorf("expected no errors reported and no streams, got %d errors and %d streams", len(rec.errs), len(rec.starts))
+	}
+}
+
+func TestStartLogStream_ExcludedContainers(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app", "istio-proxy")
+	clientset, _ := newClientset(pod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{
+		Retry:   noRetries(),
+		Targets: selector(t, nil, nil).WithContainers(nil, mustParseNamePatterns(t, "*-proxy")),
+	}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	k.startLogStream(ctx, podLister(t, pod), pod, time.Now().Add(-time.Minute))
+
+	waitFor(t, "app stream to stop", func() bool { return rec.hasStop("default/web-0:app") })
+
+	for _, opts := range logRequests(clientset) {
+		if opts.Container != "app" {
+			t.Errorf("expected no log request for container %q", opts.Container)
+		}
+	}
+}
+
+func mustParseNamePatterns(t *testing.T, patterns ...string) []*namespace.Pattern {
+	t.Helper()
+
+	parsed, err := namespace.ParseNamePatterns(patterns)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return parsed
+}
@@ -0,0 +1,144 @@
+package kat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultMaxLineLength is the maximum line length used when none is
+// configured.
+const DefaultMaxLineLength = 1024 * 1024
+
+// minMaxLineLength keeps the first chunk of a line long enough to
+// hold the timestamp the kubelet prefixes it with.
+const minMaxLineLength = 64
+
+const (
+	// LineTruncatedMarker is appended to lines that were truncated.
+	LineTruncatedMarker = " [truncated]"
+
+	// LineContinuedMarker is appended to every chunk of a split
+	// line except the last.
+	LineContinuedMarker = " [continued]"
+)
+
+// LongLineMode selects how lines longer than the maximum line length
+// are handled.
+type LongLineMode int
+
+const (
+	LongLineSplit    LongLineMode = iota // Deliver the line in chunks.
+	LongLineTruncate                     // Deliver the start of the line and drop the rest.
+)
+
+// String returns the flag value naming the mode.
+func (m LongLineMode) String() string {
+	switch m {
+	case LongLineTruncate:
+		return "truncate"
+	default:
+		return "split"
+	}
+}
+
+// ParseLongLineMode parses the name of a long line mode, as
+// returned by LongLineMode.String.
+func ParseLongLineMode(name string) (LongLineMode, error) {
+	switch name {
+	case "split":
+		return LongLineSplit, nil
+	case "truncate":
+		return LongLineTruncate, nil
+	default:
+		return 0, fmt.Errorf("unknown long line mode %q (expected split or truncate)", name)
+	}
+}
+
+// maxLineLength returns the configured maximum line length, or the
+// default if none is configured.
+func (c *StreamConfig) maxLineLength() int {
+	switch {
+	case c.MaxLineLength <= 0:
+		return DefaultMaxLineLength
+	case c.MaxLineLength < minMaxLineLength:
+		return minMaxLineLength
+	default:
+		return c.MaxLineLength
+	}
+}
+
+// lineBufferSize is the size of the read buffer of each stream.
+// Longer lines are assembled in a buffer that grows as needed, up to
+// the maximum line length, so that the many streams kat may hold
+// open do not each reserve room for the longest possible line.
+const lineBufferSize = 4096
+
+// lineReader reads newline-terminated lines in chunks of bounded
+// length, so that an arbitrarily long line neither exhausts memory
+// nor ends the stream.
+type lineReader struct {
+	r         *bufio.Reader
+	maxLength int
+	chunk     []byte // The chunk being assembled, reused between chunks.
+}
+
+func newLineReader(r io.Reader, maxLength int) *lineReader {
+	return &lineReader{
+		r:         bufio.NewReaderSize(r, min(maxLength, lineBufferSize)),
+		maxLength: maxLength,
+	}
+}
+
+// next returns the next chunk of the current line, without its line
+// terminator, and whether the line continues in the following
+// chunk. A final line that is not newline-terminated is returned
+// together with the error that ended the stream.
+func (lr *lineReader) next() (chunk string, more bool, err error) {
+	lr.chunk = lr.chunk[:0]
+
+	for len(lr.chunk) < lr.maxLength {
+		if lr.r.Buffered() == 0 {
+			if _, err := lr.r.Peek(1); err != nil {
+				return lr.take(), false, err
+			}
+		}
+
+		// Consume only what is buffered, so that a line is
+		// returned as soon as its newline has been read.
+		data, _ := lr.r.Peek(min(lr.r.Buffered(), lr.maxLength-len(lr.chunk)))
+
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			lr.chunk = append(lr.chunk, data[:i]...)
+			lr.r.Discard(i + 1)
+
+			return lr.take(), false, nil
+		}
+
+		lr.chunk = append(lr.chunk, data...)
+		lr.r.Discard(len(data))
+	}
+
+	// Do not leave an empty final chunk when the line ends exactly
+	// at the chunk boundary.
+	if next, _ := lr.r.Peek(1); len(next) == 1 && next[0] == '\n' {
+		lr.r.Discard(1)
+		return lr.take(), false, nil
+	}
+
+	return string(lr.chunk), true, nil
+}
+
+// take returns the assembled chunk without any trailing carriage
+// return, releasing the buffer if a long line has grown it.
+func (lr *lineReader) take() string {
+	chunk := strings.TrimSuffix(string(lr.chunk), "\r")
+
+	if cap(lr.chunk) > lineBufferSize {
+		lr.chunk = nil
+	}
+
+	return chunk
+}
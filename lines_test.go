@@ -0,0 +1,161 @@
+package kat
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestLineReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string // Chunks, with "+" appended to those that continue.
+	}{
+		{
+			name:     "short lines",
+			input:    "one\ntwo\n",
+			expected: []string{"one", "two"},
+		},
+		{
+			name:     "final line without newline",
+			input:    "one\ntwo",
+			expected: []string{"one", "two"},
+		},
+		{
+			name:     "carriage returns",
+			input:    "one\r\ntwo\r\n",
+			expected: []string{"one", "two"},
+		},
+		{
+			name:     "empty lines",
+			input:    "\none\n\n",
+			expected: []string{"", "one", ""},
+		},
+		{
+			name:     "long line",
+			input:    strings.Repeat("a", 40) + "\nb\n",
+			expected: []string{strings.Repeat("a", 16) + "+", strings.Repeat("a", 16) + "+", strings.Repeat("a", 8), "b"},
+		},
+		{
+			name:     "line ending at chunk boundary",
+			input:    strings.Repeat("a", 32) + "\nb\n",
+			expected: []string{strings.Repeat("a", 16) + "+", strings.Repeat("a", 16), "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := newLineReader(strings.NewReader(tt.input), 16)
+
+			var chunks []string
+			for {
+				chunk, more, err := lines.next()
+				if chunk != "" || err == nil {
+					if more {
+						chunk += "+"
+					}
+					chunks = append(chunks, chunk)
+				}
+
+				if err != nil {
+					break
+				}
+			}
+
+			if !slices.Equal(chunks, tt.expected) {
+				t.Errorf("expected chunks %q, got %q", tt.expected, chunks)
+			}
+		})
+	}
+}
+
+func TestLineReader_LinesLongerThanBuffer(t *testing.T) {
+	long := strings.Repeat("a", 3*lineBufferSize+1)
+	lines := newLineReader(strings.NewReader(long+"\r\nb\n"), DefaultMaxLineLength)
+
+	for _, expected := range []string{long, "b"} {
+		chunk, more, err := lines.next()
+		if err != nil || more {
+			t.Fatalf("unexpected result: more=%v err=%v", more, err)
+		}
+
+		if chunk != expected {
+			t.Errorf("expected a %d byte line, got %d bytes", len(expected), len(chunk))
+		}
+	}
+
+	if lines.r.Size() != lineBufferSize {
+		t.Errorf("expected a %d byte read buffer, got %d", lineBufferSize, lines.r.Size())
+	}
+}
+
+func TestCopyLines_LongLines(t *testing.T) {
+	long := strings.Repeat("x", 150)
+	input := strings.Join([]string{
+		"2025-01-06T15:30:00.1Z short",
+		"2025-01-06T15:30:00.2Z " + long,
+		"2025-01-06T15:30:00.3Z after",
+	}, "\n")
+
+	// The first chunk holds the 23 byte timestamp prefix.
+	tests := []struct {
+		name     string
+		mode     LongLineMode
+		expected []string
+	}{
+		{
+			name: "split",
+			mode: LongLineSplit,
+			expected: []string{
+				"short",
+				long[:41] + LineContinuedMarker,
+				long[41:105] + LineContinuedMarker,
+				long[105:],
+				"after",
+			},
+		},
+		{
+			name: "truncate",
+			mode: LongLineTruncate,
+			expected: []string{
+				"short",
+				long[:41] + LineTruncatedMarker,
+				"after",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				lines   []string
+				lengths []int
+			)
+
+			k := New(nil, &StreamConfig{MaxLineLength: 64, LongLines: tt.mode}, &OutputConfig{}, &Callbacks{
+				OnLogLine: func(_, _, _, line string) {
+					lines = append(lines, line)
+				},
+				OnLongLine: func(_, _, _ string, length int) {
+					lengths = append(lengths, length)
+				},
+			})
+
+			cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+			if _, err := k.copyLines(cs, 0, strings.NewReader(input), &logCursor{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !slices.Equal(lines, tt.expected) {
+				t.Errorf("expected lines %q, got %q", tt.expected, lines)
+			}
+
+			if !slices.Equal(lengths, []int{len(long)}) {
+				t.Errorf("expected a long line of %d bytes to be reported, got %v", len(long), lengths)
+			}
+		})
+	}
+}
@@ -15,9 +15,17 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+// Pattern selects namespaces, and optionally pods and containers
+// within them, by name. Patterns take the form
+// namespace[/pod][:container], where each part is either a literal
+// name or a glob. Omitted pod and container parts match every pod
+// and container.
 type Pattern struct {
-	original string
-	isGlob   bool
+	original  string
+	isGlob    bool   // The namespace part is a glob.
+	namespace string // Namespace part.
+	pod       string // Pod part; empty matches every pod.
+	container string // Container part; empty matches every container.
 }
 
 func newPattern(pattern string) (*Pattern, error) {
@@ -25,27 +33,81 @@ func newPattern(pattern string) (*Pattern, error) {
 		return nil, fmt.Errorf("pattern cannot be empty")
 	}
 
-	isGlob := strings.ContainsAny(pattern, "*?[]")
+	rest, container, hasContainer := strings.Cut(pattern, ":")
+	namespace, pod, hasPod := strings.Cut(rest, "/")
+
+	parts := []struct {
+		name    string
+		value   string
+		present bool
+	}{
+		{"namespace", namespace, true},
+		{"pod", pod, hasPod},
+		{"container", container, hasContainer},
+	}
+
+	for _, part := range parts {
+		if !part.present {
+			continue
+		}
+
+		if part.value == "" {
+			return nil, fmt.Errorf("%s in pattern %q cannot be empty", part.name, pattern)
+		}
+
+		if strings.ContainsAny(part.value, "/:") {
+			return nil, fmt.Errorf("invalid %s %q in pattern %q", part.name, part.value, pattern)
+		}
 
-	if isGlob {
-		if _, err := filepath.Match(pattern, "test"); err != nil {
-			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		if _, err := filepath.Match(part.value, "test"); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", part.value, err)
 		}
 	}
 
 	return &Pattern{
-		original: pattern,
-		isGlob:   isGlob,
+		original:  pattern,
+		isGlob:    isGlob(namespace),
+		namespace: namespace,
+		pod:       pod,
+		container: container,
 	}, nil
 }
 
-func (p *Pattern) match(namespace string) bool {
-	if p.isGlob {
-		// We validated this pattern in NewPattern, so this should never error.
-		match, _ := filepath.Match(p.original, namespace)
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[]")
+}
+
+// matchName matches a name against a literal or glob pattern. An
+// empty pattern matches every name.
+func matchName(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	if isGlob(pattern) {
+		// We validated this pattern in newPattern, so this should never error.
+		match, _ := filepath.Match(pattern, name)
 		return match
 	}
-	return p.original == namespace
+
+	return pattern == name
+}
+
+func (p *Pattern) match(namespace string) bool {
+	return matchName(p.namespace, namespace)
+}
+
+func (p *Pattern) matchPod(namespace, pod string) bool {
+	return p.match(namespace) && matchName(p.pod, pod)
+}
+
+func (p *Pattern) matchContainer(namespace, pod, container string) bool {
+	return p.matchPod(namespace, pod) && matchName(p.container, container)
+}
+
+// Namespace returns the namespace part of the pattern.
+func (p *Pattern) Namespace() string {
+	return p.namespace
 }
 
 func (p *Pattern) String() string {
@@ -69,21 +131,39 @@ func ParsePatterns(patterns []string) ([]*Pattern, error) {
 	return parsed, nil
 }
 
+// ParseNamePatterns parses patterns that match a single name, such
+// as a container name, rather than a namespace[/pod][:container]
+// target.
+func ParseNamePatterns(patterns []string) ([]*Pattern, error) {
+	parsed, err := ParsePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range parsed {
+		if pattern.pod != "" || pattern.container != "" {
+			return nil, fmt.Errorf("pattern %q must match a name, not a namespace/pod:container target", pattern)
+		}
+	}
+
+	return parsed, nil
+}
+
 type NamespaceHandler interface {
 	OnNamespaceAdded(namespace string) error
 	OnNamespaceDeleted(namespace string) error
 }
 
 type InformerWatcher struct {
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	factory   informers.SharedInformerFactory
 	informer  cache.SharedIndexInformer
 	lister    v1.NamespaceLister
 	stopCh    chan struct{}
 }
 
-func NewInformerWatcher(clientset *kubernetes.Clientset) *InformerWatcher {
-	factory := informers.NewSharedInformerFactory(clientset, 0)
+func NewInformerWatcher(clientset kubernetes.Interface) *InformerWatcher {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTransform(transformNamespace))
 	informer := factory.Core().V1().Namespaces().Informer()
 	lister := factory.Core().V1().Namespaces().Lister()
 
@@ -95,6 +175,23 @@ func NewInformerWatcher(clientset *kubernetes.Clientset) *InformerWatcher {
 	}
 }
 
+// transformNamespace reduces a namespace to its identity before it
+// is cached, as only its name is matched against patterns.
+func transformNamespace(obj any) (any, error) {
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return obj, nil
+	}
+
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            namespace.Name,
+			UID:             namespace.UID,
+			ResourceVersion: namespace.ResourceVersion,
+		},
+	}, nil
+}
+
 func (w *InformerWatcher) Start(ctx context.Context, includePatterns, excludePatterns []*Pattern, handler NamespaceHandler) error {
 	_, err := w.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{Limit: 1})
 	if err != nil {
@@ -152,26 +249,5 @@ func (w *InformerWatcher) Stop() {
 }
 
 func (w *InformerWatcher) shouldIncludeNamespace(namespace string, includePatterns, excludePatterns []*Pattern) bool {
-	included := len(includePatterns) == 0
-
-	if len(includePatterns) > 0 {
-		for _, pattern := range includePatterns {
-			if pattern.match(namespace) {
-				included = true
-				break
-			}
-		}
-	}
-
-	if !included {
-		return false
-	}
-
-	for _, pattern := range excludePatterns {
-		if pattern.match(namespace) {
-			return false
-		}
-	}
-
-	return true
+	return NewSelector(includePatterns, excludePatterns).IncludesNamespace(namespace)
 }
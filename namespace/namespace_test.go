@@ -48,6 +48,54 @@ func TestNewPattern(t *testing.T) {
 			expectErr:    true,
 			expectIsGlob: false,
 		},
+		{
+			name:         "namespace and pod",
+			pattern:      "shop/web-*",
+			expectErr:    false,
+			expectIsGlob: false,
+		},
+		{
+			name:         "namespace, pod and container",
+			pattern:      "shop-*/web-*:app",
+			expectErr:    false,
+			expectIsGlob: true,
+		},
+		{
+			name:         "namespace and container",
+			pattern:      "shop-*:app",
+			expectErr:    false,
+			expectIsGlob: true,
+		},
+		{
+			name:         "empty namespace",
+			pattern:      "/web-0",
+			expectErr:    true,
+			expectIsGlob: false,
+		},
+		{
+			name:         "empty pod",
+			pattern:      "shop/:app",
+			expectErr:    true,
+			expectIsGlob: false,
+		},
+		{
+			name:         "empty container",
+			pattern:      "shop/web-0:",
+			expectErr:    true,
+			expectIsGlob: false,
+		},
+		{
+			name:         "too many parts",
+			pattern:      "shop/web-0/app",
+			expectErr:    true,
+			expectIsGlob: false,
+		},
+		{
+			name:         "invalid glob in container",
+			pattern:      "shop/web-0:[app",
+			expectErr:    true,
+			expectIsGlob: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -132,6 +180,12 @@ func TestPattern_Match(t *testing.T) {
 			namespace:   "test-d",
 			expectMatch: false,
 		},
+		{
+			name:        "namespace part of a pod pattern",
+			pattern:     "shop-*/web-*:app",
+			namespace:   "shop-eu",
+			expectMatch: true,
+		},
 	}
 
 	for _, tt := range tests {
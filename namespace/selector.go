@@ -0,0 +1,122 @@
+package namespace
+
+// Selector selects namespaces, pods and containers by matching their
+// names against include and exclude patterns. With no include
+// patterns everything is included. An exclude pattern removes only
+// what it fully covers: a pattern naming a container excludes that
+// container but not its pod, and a pattern naming a pod excludes
+// that pod but not its namespace.
+type Selector struct {
+	include []*Pattern
+	exclude []*Pattern
+
+	// Container name filters applied on top of the patterns.
+	containers        []*Pattern
+	excludeContainers []*Pattern
+}
+
+func NewSelector(includePatterns, excludePatterns []*Pattern) *Selector {
+	return &Selector{
+		include: includePatterns,
+		exclude: excludePatterns,
+	}
+}
+
+// WithContainers returns a copy of the selector that further
+// restricts containers, in every pod, to those whose names match one
+// of the include patterns, or any name if there are none, and none
+// of the exclude patterns. The patterns are matched against the
+// container name alone; see ParseNamePatterns.
+func (s *Selector) WithContainers(includePatterns, excludePatterns []*Pattern) *Selector {
+	selector := *s
+	selector.containers = includePatterns
+	selector.excludeContainers = excludePatterns
+
+	return &selector
+}
+
+// IncludesNamespace reports whether any pods in the namespace may be
+// selected.
+func (s *Selector) IncludesNamespace(namespace string) bool {
+	if !s.included(func(p *Pattern) bool { return p.match(namespace) }) {
+		return false
+	}
+
+	for _, pattern := range s.exclude {
+		if pattern.pod == "" && pattern.container == "" && pattern.match(namespace) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IncludesPod reports whether any containers in the pod may be
+// selected.
+func (s *Selector) IncludesPod(namespace, pod string) bool {
+	if !s.included(func(p *Pattern) bool { return p.matchPod(namespace, pod) }) {
+		return false
+	}
+
+	for _, pattern := range s.exclude {
+		if pattern.container == "" && pattern.matchPod(namespace, pod) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IncludesContainer reports whether the container is selected.
+func (s *Selector) IncludesContainer(namespace, pod, container string) bool {
+	if !s.included(func(p *Pattern) bool { return p.matchContainer(namespace, pod, container) }) {
+		return false
+	}
+
+	for _, pattern := range s.exclude {
+		if pattern.matchContainer(namespace, pod, container) {
+			return false
+		}
+	}
+
+	return matchNames(s.containers, s.excludeContainers, container)
+}
+
+// matchNames reports whether name matches one of the include
+// patterns, or there are none, and none of the exclude patterns.
+func matchNames(includePatterns, excludePatterns []*Pattern, name string) bool {
+	included := len(includePatterns) == 0
+
+	for _, pattern := range includePatterns {
+		if pattern.match(name) {
+			included = true
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, pattern := range excludePatterns {
+		if pattern.match(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Selector) included(match func(*Pattern) bool) bool {
+	if len(s.include) == 0 {
+		return true
+	}
+
+	for _, pattern := range s.include {
+		if match(pattern) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,248 @@
+package namespace
+
+import "testing"
+
+func TestSelector(t *testing.T) {
+	type target struct {
+		namespace string
+		pod       string
+		container string
+	}
+
+	tests := []struct {
+		name            string
+		include         []string
+		exclude         []string
+		target          target
+		expectNamespace bool
+		expectPod       bool
+		expectContainer bool
+	}{
+		{
+			name:            "no patterns",
+			target:          target{"shop-eu", "web-0", "app"},
+			expectNamespace: true,
+			expectPod:       true,
+			expectContainer: true,
+		},
+		{
+			name:            "namespace pattern",
+			include:         []string{"shop-*"},
+			target:          target{"shop-eu", "web-0", "app"},
+			expectNamespace: true,
+			expectPod:       true,
+			expectContainer: true,
+		},
+		{
+			name:            "pod pattern",
+			include:         []string{"shop-*/web-*"},
+			target:          target{"shop-eu", "api-0", "app"},
+			expectNamespace: true,
+			expectPod:       false,
+			expectContainer: false,
+		},
+		{
+			name:            "container pattern",
+			include:         []string{"shop-*/web-*:app"},
+			target:          target{"shop-eu", "web-0", "sidecar"},
+			expectNamespace: true,
+			expectPod:       true,
+			expectContainer: false,
+		},
+		{
+			name:            "container pattern without pod",
+			include:         []string{"shop-*:app"},
+			target:          target{"shop-eu", "api-0", "app"},
+			expectNamespace: true,
+			expectPod:       true,
+			expectContainer: true,
+		},
+		{
+			name:            "any of several patterns",
+			include:         []string{"shop-*/web-*:app", "shop-*/api-*"},
+			target:          target{"shop-eu", "api-0", "sidecar"},
+			expectNamespace: true,
+			expectPod:       true,
+			expectContainer: true,
+		},
+		{
+			name:            "other namespace",
+			include:         []string{"shop-*/web-*:app"},
+			target:          target{"billing", "web-0", "app"},
+			expectNamespace: false,
+			expectPod:       false,
+			expectContainer: false,
+		},
+		{
+			name:            "excluded namespace",
+			include:         []string{"shop-*"},
+			exclude:         []string{"shop-dev"},
+			target:          target{"shop-dev", "web-0", "app"},
+			expectNamespace: false,
+			expectPod:       false,
+			expectContainer: false,
+		},
+		{
+			name:            "excluded pod",
+			include:         []string{"shop-*"},
+			exclude:         []string{"shop-*/web-*"},
+			target:          target{"shop-eu", "web-0", "app"},
+			expectNamespace: true,
+			expectPod:       false,
+			expectContainer: false,
+		},
+		{
+			name:            "excluded container",
+			exclude:         []string{"*:istio-proxy"},
+			target:          target{"shop-eu", "web-0", "istio-proxy"},
+			expectNamespace: true,
+			expectPod:       true,
+			expectContainer: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			includePatterns, err := ParsePatterns(tt.include)
+			if err != nil {
+				t.Fatalf("failed to parse include patterns: %v", err)
+			}
+
+			excludePatterns, err := ParsePatterns(tt.exclude)
+			if err != nil {
+				t.Fatalf("failed to parse exclude patterns: %v", err)
+			}
+
+			selector := NewSelector(includePatterns, excludePatterns)
+
+			if got := selector.IncludesNamespace(tt.target.namespace); got != tt.expectNamespace {
+				t.Errorf("expected IncludesNamespace=%v, got %v", tt.expectNamespace, got)
+			}
+
+			if got := selector.IncludesPod(tt.target.namespace, tt.target.pod); got != tt.expectPod {
+				t.Errorf("expected IncludesPod=%v, got %v", tt.expectPod, got)
+			}
+
+			if got := selector.IncludesContainer(tt.target.namespace, tt.target.pod, tt.target.container); got != tt.expectContainer {
+				t.Errorf("expected IncludesContainer=%v, got %v", tt.expectContainer, got)
+			}
+		})
+	}
+}
+
+func TestSelector_WithContainers(t *testing.T) {
+	tests := []struct {
+		name      string
+		include   []string
+		exclude   []string
+		container string
+		expected  bool
+	}{
+		{
+			name:      "no container patterns",
+			container: "app",
+			expected:  true,
+		},
+		{
+			name:      "excluded sidecar",
+			exclude:   []string{"istio-proxy", "linkerd-proxy"},
+			container: "istio-proxy",
+			expected:  false,
+		},
+		{
+			name:      "other container with sidecars excluded",
+			exclude:   []string{"*-proxy"},
+			container: "app",
+			expected:  true,
+		},
+		{
+			name:      "included container",
+			include:   []string{"app*"},
+			container: "app-worker",
+			expected:  true,
+		},
+		{
+			name:      "container not included",
+			include:   []string{"app*"},
+			container: "metrics",
+			expected:  false,
+		},
+		{
+			name:      "included and excluded",
+			include:   []string{"app*"},
+			exclude:   []string{"app-debug"},
+			container: "app-debug",
+			expected:  false,
+		},
+	}
+
+	patterns, err := ParsePatterns([]string{"shop-*/web-*"})
+	if err != nil {
+		t.Fatalf("failed to parse patterns: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			includeContainers, err := ParseNamePatterns(tt.include)
+			if err != nil {
+				t.Fatalf("failed to parse include patterns: %v", err)
+			}
+
+			excludeContainers, err := ParseNamePatterns(tt.exclude)
+			if err != nil {
+				t.Fatalf("failed to parse exclude patterns: %v", err)
+			}
+
+			selector := NewSelector(patterns, nil).WithContainers(includeContainers, excludeContainers)
+
+			if got := selector.IncludesContainer("shop-eu", "web-0", tt.container); got != tt.expected {
+				t.Errorf("expected IncludesContainer=%v, got %v", tt.expected, got)
+			}
+
+			if selector.IncludesContainer("shop-eu", "api-0", tt.container) {
+				t.Errorf("expected containers outside the selected pods to stay excluded")
+			}
+		})
+	}
+}
+
+func TestParseNamePatterns(t *testing.T) {
+	tests := []struct {
+		name      string
+		patterns  []string
+		expectErr bool
+	}{
+		{
+			name:     "names and globs",
+			patterns: []string{"istio-proxy", "*-proxy"},
+		},
+		{
+			name:      "target pattern",
+			patterns:  []string{"shop/web-0"},
+			expectErr: true,
+		},
+		{
+			name:      "container pattern",
+			patterns:  []string{"*:istio-proxy"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid glob",
+			patterns:  []string{"[proxy"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseNamePatterns(tt.patterns)
+			if tt.expectErr && err == nil {
+				t.Errorf("expected error but got none")
+			}
+
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
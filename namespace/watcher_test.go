@@ -0,0 +1,198 @@
+package namespace
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// recordingHandler records namespace events delivered by the watcher.
+type recordingHandler struct {
+	mu      sync.Mutex
+	added   []string
+	deleted []string
+}
+
+func (h *recordingHandler) OnNamespaceAdded(namespace string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.added = append(h.added, namespace)
+	return nil
+}
+
+func (h *recordingHandler) OnNamespaceDeleted(namespace string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deleted = append(h.deleted, namespace)
+	return nil
+}
+
+func (h *recordingHandler) snapshot() (added, deleted []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.added), slices.Clone(h.deleted)
+}
+
+func newNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func mustParsePatterns(t *testing.T, patterns ...string) []*Pattern {
+	t.Helper()
+
+	parsed, err := ParsePatterns(patterns)
+	if err != nil {
+		t.Fatalf("failed to parse patterns %v: %v", patterns, err)
+	}
+
+	return parsed
+}
+
+// newClientset returns a fake clientset and a channel that is
+// closed once the namespace watch has been established, after which
+// created and deleted namespaces are delivered as watch events.
+func newClientset(objects ...runtime.Object) (*fake.Clientset, <-chan struct{}) {
+	clientset := fake.NewClientset(objects...)
+	watching := make(chan struct{})
+
+	var once sync.Once
+
+	clientset.PrependWatchReactor("namespaces", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+
+		once.Do(func() { close(watching) })
+
+		return true, w, nil
+	})
+
+	return clientset, watching
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInformerWatcher_Start(t *testing.T) {
+	clientset, watching := newClientset(
+		newNamespace("frontend-prod"),
+		newNamespace("frontend-dev"),
+		newNamespace("kube-system"),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := &recordingHandler{}
+	watcher := NewInformerWatcher(clientset)
+	defer watcher.Stop()
+
+	err := watcher.Start(ctx, mustParsePatterns(t, "frontend-*", "backend-*"), mustParsePatterns(t, "*-dev"), handler)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	added, _ := handler.snapshot()
+	if !slices.Contains(added, "frontend-prod") {
+		t.Errorf("expected existing namespace frontend-prod to be added, got %v", added)
+	}
+
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for namespace watch")
+	}
+
+	namespaces := clientset.CoreV1().Namespaces()
+
+	for _, name := range []string{"backend-prod", "backend-dev", "other"} {
+		if _, err := namespaces.Create(ctx, newNamespace(name), metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create namespace %s: %v", name, err)
+		}
+	}
+
+	waitFor(t, "backend-prod to be added", func() bool {
+		added, _ := handler.snapshot()
+		return slices.Contains(added, "backend-prod")
+	})
+
+	for _, name := range []string{"backend-prod", "kube-system"} {
+		if err := namespaces.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("failed to delete namespace %s: %v", name, err)
+		}
+	}
+
+	waitFor(t, "backend-prod to be deleted", func() bool {
+		_, deleted := handler.snapshot()
+		return slices.Contains(deleted, "backend-prod")
+	})
+
+	added, deleted := handler.snapshot()
+
+	for _, name := range []string{"frontend-dev", "kube-system", "backend-dev", "other"} {
+		if slices.Contains(added, name) {
+			t.Errorf("expected namespace %s to be filtered out, got added=%v", name, added)
+		}
+	}
+
+	if slices.Contains(deleted, "kube-system") {
+		t.Errorf("expected deletion of kube-system to be filtered out, got deleted=%v", deleted)
+	}
+}
+
+func TestInformerWatcher_StartListError(t *testing.T) {
+	clientset, _ := newClientset()
+	clientset.PrependReactor("list", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, context.DeadlineExceeded
+	})
+
+	watcher := NewInformerWatcher(clientset)
+	defer watcher.Stop()
+
+	if err := watcher.Start(context.Background(), nil, nil, &recordingHandler{}); err == nil {
+		t.Fatal("expected error but got none")
+	}
+}
+
+func TestInformerWatcher_CachesReducedNamespaces(t *testing.T) {
+	frontend := newNamespace("frontend")
+	frontend.Labels = map[string]string{"team": "web"}
+	frontend.Spec.Finalizers = []corev1.FinalizerName{corev1.FinalizerKubernetes}
+
+	clientset, _ := newClientset(frontend)
+
+	watcher := NewInformerWatcher(clientset)
+	defer watcher.Stop()
+
+	if err := watcher.Start(context.Background(), nil, nil, &recordingHandler{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cached, err := watcher.lister.Get("frontend")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cached.Name != "frontend" || cached.Labels != nil || len(cached.Spec.Finalizers) != 0 {
+		t.Errorf("expected only the namespace's identity to be cached, got %+v", cached)
+	}
+}
@@ -0,0 +1,153 @@
+package kat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/metadata"
+)
+
+// maxOwnerDepth bounds the length of the ownership chains followed
+// from a pod, guarding against cycles and runaway lookups.
+const maxOwnerDepth = 8
+
+// MetadataClient provides access to the metadata of objects of any
+// kind, which is needed to follow ownership chains through custom
+// resources.
+type MetadataClient struct {
+	Client metadata.Interface
+	Mapper meta.RESTMapper
+}
+
+// ownerCacheTTL is how long the owner references of an object are
+// cached before they are looked up again.
+const ownerCacheTTL = 5 * time.Minute
+
+// ownerChains follows ownerReferences upward from pods using the
+// metadata API. The owner references of each object looked up are
+// cached by UID for ownerCacheTTL. Owner references can change, as
+// when a controller adopts or orphans an object, so a change is
+// seen by pods decided once the cached references have expired.
+type ownerChains struct {
+	client  metadata.Interface
+	mapper  meta.RESTMapper
+	ttl     time.Duration
+	parents sync.Map // Cached ownerEntry of each object, by UID.
+}
+
+// ownerEntry holds the owner references of an object looked up.
+type ownerEntry struct {
+	parents []metav1.OwnerReference
+	expires time.Time
+}
+
+func newOwnerChains(client *MetadataClient) *ownerChains {
+	if client == nil {
+		return nil
+	}
+
+	return &ownerChains{
+		client: client.Client,
+		mapper: client.Mapper,
+		ttl:    ownerCacheTTL,
+	}
+}
+
+// resolve returns the UID of the object of the given kind and name.
+func (c *ownerChains) resolve(ctx context.Context, namespace string, kind schema.GroupKind, name string) (types.UID, error) {
+	object, err := c.get(ctx, namespace, kind, "", name)
+	if err != nil {
+		return "", err
+	}
+
+	return object.UID, nil
+}
+
+// reaches reports whether the ownership chain of an object in the
+// namespace with the given owner references reaches an object for
+// which target returns true.
+func (c *ownerChains) reaches(ctx context.Context, namespace string, refs []metav1.OwnerReference, target func(types.UID) bool) (bool, error) {
+	visited := make(map[types.UID]bool)
+
+	for depth := 0; len(refs) > 0 && depth < maxOwnerDepth; depth++ {
+		var next []metav1.OwnerReference
+
+		for _, ref := range refs {
+			if target(ref.UID) {
+				return true, nil
+			}
+
+			if visited[ref.UID] {
+				continue
+			}
+
+			visited[ref.UID] = true
+
+			parents, err := c.ownersOf(ctx, namespace, ref)
+			if err != nil {
+				return false, err
+			}
+
+			next = append(next, parents...)
+		}
+
+		refs = next
+	}
+
+	return false, nil
+}
+
+// ownersOf returns the owner references of the object referred to
+// by ref. An owner that no longer exists has no owners.
+func (c *ownerChains) ownersOf(ctx context.Context, namespace string, ref metav1.OwnerReference) ([]metav1.OwnerReference, error) {
+	if entry, ok := c.parents.Load(ref.UID); ok && time.Now().Before(entry.(ownerEntry).expires) {
+		return entry.(ownerEntry).parents, nil
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner reference to %s %s: %w", ref.Kind, ref.Name, err)
+	}
+
+	var parents []metav1.OwnerReference
+
+	object, err := c.get(ctx, namespace, gv.WithKind(ref.Kind).GroupKind(), gv.Version, ref.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	case object.UID == ref.UID:
+		parents = object.OwnerReferences
+	}
+
+	c.parents.Store(ref.UID, ownerEntry{parents: parents, expires: time.Now().Add(c.ttl)})
+
+	return parents, nil
+}
+
+// get fetches the metadata of an object, which is looked up in the
+// namespace if its kind is namespaced.
+func (c *ownerChains) get(ctx context.Context, namespace string, kind schema.GroupKind, version, name string) (*metav1.PartialObjectMetadata, error) {
+	var versions []string
+	if version != "" {
+		versions = append(versions, version)
+	}
+
+	mapping, err := c.mapper.RESTMapping(kind, versions...)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping %s: %w", kind, err)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = ""
+	}
+
+	return c.client.Resource(mapping.Resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+}
@@ -0,0 +1,210 @@
+package kat
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var databaseKind = schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Database"}
+
+// newMetadataClient returns a metadata client serving the given
+// objects and a REST mapper that knows their kinds.
+func newMetadataClient(objects ...*metav1.PartialObjectMetadata) *MetadataClient {
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion, databaseKind.GroupVersion()})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), meta.RESTScopeNamespace)
+	mapper.Add(databaseKind, meta.RESTScopeNamespace)
+
+	runtimeObjects := make([]runtime.Object, 0, len(objects))
+	for _, object := range objects {
+		runtimeObjects = append(runtimeObjects, object)
+	}
+
+	return &MetadataClient{
+		Client: metadatafake.NewSimpleMetadataClient(scheme, runtimeObjects...),
+		Mapper: mapper,
+	}
+}
+
+func newObjectMetadata(gvk schema.GroupVersionKind, namespace, name string, owner *metav1.PartialObjectMetadata) *metav1.PartialObjectMetadata {
+	object := &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID("uid-" + name),
+		},
+	}
+
+	if owner != nil {
+		object.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, owner.GroupVersionKind())}
+	}
+
+	return object
+}
+
+func TestStartStreamingWorkloads_OwnerChain(t *testing.T) {
+	statefulSetKind := appsv1.SchemeGroupVersion.WithKind("StatefulSet")
+
+	orders := newObjectMetadata(databaseKind, "shop", "orders", nil)
+	ordersStatefulSet := newObjectMetadata(statefulSetKind, "shop", "orders-db", orders)
+	cacheStatefulSet := newObjectMetadata(statefulSetKind, "shop", "cache", nil)
+
+	ordersPod := newPod("shop", "orders-db-0", corev1.PodRunning, "db")
+	ordersPod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(ordersStatefulSet, statefulSetKind)}
+
+	cachePod := newPod("shop", "cache-0", corev1.PodRunning, "db")
+	cachePod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(cacheStatefulSet, statefulSetKind)}
+
+	clientset, watching := newClientset(ordersPod, cachePod)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{
+		Retry:    noRetries(),
+		Metadata: newMetadataClient(orders, ordersStatefulSet, cacheStatefulSet),
+	}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	workload, _, err := ParseWorkload("owner=example.com/Database/orders")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	workload.Namespace = "shop"
+
+	go k.StartStreamingWorkloads(ctx, []Workload{workload}, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+
+	// A new pod of the operator's StatefulSet is picked up.
+	scaled := newPod("shop", "orders-db-1", corev1.PodRunning, "db")
+	scaled.OwnerReferences = ordersPod.OwnerReferences
+	if _, err := clientset.CoreV1().Pods("shop").Create(ctx, scaled, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, stream := range []string{"shop/orders-db-0:db", "shop/orders-db-1:db"} {
+		waitFor(t, stream, func() bool { return rec.hasStop(stream) })
+	}
+
+	if rec.hasStart("shop/cache-0:db") {
+		t.Errorf("expected no stream for a pod owned by another object")
+	}
+}
+
+func TestStartStreamingWorkloads_OwnerWithoutMetadataClient(t *testing.T) {
+	clientset, _ := newClientset()
+	k := New(clientset, nil, &OutputConfig{}, nil)
+
+	workload := Workload{Kind: WorkloadOwner, Namespace: "shop", Name: "orders", Owner: databaseKind.GroupKind()}
+	if err := k.StartStreamingWorkloads(context.Background(), []Workload{workload}, time.Minute); err == nil {
+		t.Fatal("expected an error without a metadata client")
+	}
+}
+
+func TestStartStreamingWorkloads_OwnerChainLookupRetried(t *testing.T) {
+	statefulSetKind := appsv1.SchemeGroupVersion.WithKind("StatefulSet")
+
+	orders := newObjectMetadata(databaseKind, "shop", "orders", nil)
+	ordersStatefulSet := newObjectMetadata(statefulSetKind, "shop", "orders-db", orders)
+
+	pod := newPod("shop", "orders-db-0", corev1.PodRunning, "db")
+	pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(ordersStatefulSet, statefulSetKind)}
+
+	clientset, _ := newClientset(pod)
+
+	// The first lookup of the StatefulSet fails.
+	metadataClient := newMetadataClient(orders, ordersStatefulSet)
+	var failed atomic.Bool
+	metadataClient.Client.(*metadatafake.FakeMetadataClient).PrependReactor("get", "statefulsets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failed.CompareAndSwap(false, true) {
+			return true, nil, apierrors.NewServiceUnavailable("try again")
+		}
+
+		return false, nil, nil
+	})
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries(), Metadata: metadataClient}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	workload := Workload{Kind: WorkloadOwner, Namespace: "shop", Name: "orders", Owner: databaseKind.GroupKind()}
+	go k.StartStreamingWorkloads(ctx, []Workload{workload}, time.Minute)
+
+	waitFor(t, "shop/orders-db-0:db", func() bool { return rec.hasStop("shop/orders-db-0:db") })
+
+	if !failed.Load() {
+		t.Errorf("expected the StatefulSet lookup to have failed")
+	}
+}
+
+func TestOwnerChains_CacheExpiry(t *testing.T) {
+	statefulSetKind := appsv1.SchemeGroupVersion.WithKind("StatefulSet")
+
+	orders := newObjectMetadata(databaseKind, "shop", "orders", nil)
+	ordersStatefulSet := newObjectMetadata(statefulSetKind, "shop", "orders-db", orders)
+	podOwners := []metav1.OwnerReference{*metav1.NewControllerRef(ordersStatefulSet, statefulSetKind)}
+
+	// The StatefulSet is orphaned after its first lookup.
+	orphaned := ordersStatefulSet.DeepCopy()
+	orphaned.OwnerReferences = nil
+
+	metadataClient := newMetadataClient(orders, ordersStatefulSet)
+	var lookedUp atomic.Bool
+	metadataClient.Client.(*metadatafake.FakeMetadataClient).PrependReactor("get", "statefulsets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if lookedUp.CompareAndSwap(false, true) {
+			return false, nil, nil
+		}
+
+		return true, orphaned, nil
+	})
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		reaches bool
+	}{
+		{name: "cached", ttl: time.Hour, reaches: true},
+		{name: "expired", ttl: 0, reaches: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookedUp.Store(false)
+
+			chains := newOwnerChains(metadataClient)
+			chains.ttl = tt.ttl
+
+			isOrders := func(uid types.UID) bool { return uid == orders.UID }
+
+			for i := 0; i < 2; i++ {
+				reaches, err := chains.reaches(context.Background(), "shop", podOwners, isOrders)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if want := i == 0 || tt.reaches; reaches != want {
+					t.Errorf("lookup %d: expected reaches %v, got %v", i, want, reaches)
+				}
+			}
+		})
+	}
+}
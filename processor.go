@@ -0,0 +1,82 @@
+package kat
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Processor is a stage of the pipeline between the container streams
+// and the sinks. Process receives each record and passes records on
+// to the next stage by calling emit: none to drop the record, one to
+// pass it on, changed or not, or several to split it. A processor
+// may change the line and its fields but not the StreamInfo. Like
+// sinks, processors are called concurrently for different
+// containers. An error is reported through OnError; records emitted
+// before it are still delivered.
+type Processor interface {
+	Process(record LogRecord, emit func(LogRecord)) error
+}
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc func(record LogRecord, emit func(LogRecord)) error
+
+func (f ProcessorFunc) Process(record LogRecord, emit func(LogRecord)) error {
+	return f(record, emit)
+}
+
+// NewGrep returns a processor that passes on only the records whose
+// line matches any of patterns, or, if invert is set, only those
+// matching none of them. The chunks of a split line are matched
+// separately.
+func NewGrep(patterns []*regexp.Regexp, invert bool) Processor {
+	return ProcessorFunc(func(record LogRecord, emit func(LogRecord)) error {
+		matched := false
+
+		for _, pattern := range patterns {
+			if pattern.MatchString(record.Line) {
+				matched = true
+				break
+			}
+		}
+
+		if matched != invert {
+			emit(record)
+		}
+
+		return nil
+	})
+}
+
+// NewRedactor returns a processor that replaces every match of
+// patterns in a record's line with replacement, which may refer to
+// submatches as in regexp.Regexp.ReplaceAllString.
+func NewRedactor(patterns []*regexp.Regexp, replacement string) Processor {
+	return ProcessorFunc(func(record LogRecord, emit func(LogRecord)) error {
+		for _, pattern := range patterns {
+			record.Line = pattern.ReplaceAllString(record.Line, replacement)
+		}
+
+		emit(record)
+
+		return nil
+	})
+}
+
+// NewJSONParser returns a processor that parses lines holding a JSON
+// object into the record's Fields. Other lines, including the chunks
+// of split lines, are passed on unchanged.
+func NewJSONParser() Processor {
+	return ProcessorFunc(func(record LogRecord, emit func(LogRecord)) error {
+		if !record.Continued && strings.HasPrefix(strings.TrimSpace(record.Line), "{") {
+			var fields map[string]any
+			if err := json.Unmarshal([]byte(record.Line), &fields); err == nil {
+				record.Fields = fields
+			}
+		}
+
+		emit(record)
+
+		return nil
+	})
+}
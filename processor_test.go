@@ -0,0 +1,142 @@
+package kat
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// runProcessor passes lines through a processor and returns the
+// records it emits.
+func runProcessor(t *testing.T, p Processor, lines ...string) []LogRecord {
+	t.Helper()
+
+	var emitted []LogRecord
+
+	for _, line := range lines {
+		if err := p.Process(LogRecord{Line: line}, func(record LogRecord) {
+			emitted = append(emitted, record)
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	return emitted
+}
+
+func recordLines(records []LogRecord) []string {
+	lines := make([]string, len(records))
+	for i, record := range records {
+		lines[i] = record.Line
+	}
+
+	return lines
+}
+
+func TestProcessors(t *testing.T) {
+	patterns := []*regexp.Regexp{regexp.MustCompile(`error`), regexp.MustCompile(`^WARN`)}
+	input := []string{"an error", "WARN disk", "all fine", "no WARN here"}
+
+	tests := []struct {
+		name      string
+		processor Processor
+		expected  []string
+	}{
+		{
+			name:      "grep",
+			processor: NewGrep(patterns, false),
+			expected:  []string{"an error", "WARN disk"},
+		},
+		{
+			name:      "inverted grep",
+			processor: NewGrep(patterns, true),
+			expected:  []string{"all fine", "no WARN here"},
+		},
+		{
+			name:      "redact",
+			processor: NewRedactor([]*regexp.Regexp{regexp.MustCompile(`error|WARN`)}, "***"),
+			expected:  []string{"an ***", "*** disk", "all fine", "no *** here"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if lines := recordLines(runProcessor(t, tt.processor, input...)); !slices.Equal(lines, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, lines)
+			}
+		})
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	records := runProcessor(t, NewJSONParser(), `{"level":"info","n":1}`, "plain text", `{"broken":`)
+
+	if len(records) != 3 {
+		t.Fatalf("expected every record to be passed on, got %d", len(records))
+	}
+
+	if expected := map[string]any{"level": "info", "n": float64(1)}; !reflect.DeepEqual(records[0].Fields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, records[0].Fields)
+	}
+
+	if records[1].Fields != nil || records[2].Fields != nil {
+		t.Errorf("expected no fields for lines that are not JSON objects")
+	}
+}
+
+func TestDeliverRecord_Processors(t *testing.T) {
+	var (
+		lines []string
+		errs  []error
+	)
+
+	// Split on semicolons, then drop empty parts and fail on "bad".
+	split := ProcessorFunc(func(record LogRecord, emit func(LogRecord)) error {
+		for part := range strings.SplitSeq(record.Line, ";") {
+			record.Line = part
+			emit(record)
+		}
+
+		return nil
+	})
+
+	filter := ProcessorFunc(func(record LogRecord, emit func(LogRecord)) error {
+		switch record.Line {
+		case "":
+			return nil
+		case "bad":
+			return errors.New("bad record")
+		}
+
+		emit(record)
+
+		return nil
+	})
+
+	k := New(nil, nil, &OutputConfig{Processors: []Processor{split, filter}}, &Callbacks{
+		OnLogLine: func(_, _, _, line string) {
+			lines = append(lines, line)
+		},
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	if _, err := k.copyLines(cs, 0, strings.NewReader("a;b\n;bad;c\n"), &logCursor{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := []string{"a", "b", "c"}; !slices.Equal(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("expected one processing error, got %v", errs)
+	}
+}
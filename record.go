@@ -0,0 +1,55 @@
+package kat
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// StreamInfo identifies an instance of a container being streamed.
+type StreamInfo struct {
+	Namespace string
+	PodName   string
+	PodUID    types.UID
+	Container string
+	NodeName  string
+	Labels    map[string]string // The pod's labels; must not be modified.
+	Instance  int32             // Restart count of the container instance.
+}
+
+// LogRecord is a line logged by a container, with the metadata of
+// the container instance that logged it.
+type LogRecord struct {
+	StreamInfo
+
+	Timestamp time.Time // When the kubelet received the line from the container.
+	Received  time.Time // When kat received the line.
+
+	// Line is the line without its timestamp, or a chunk of it if
+	// it was longer than the maximum line length.
+	Line      string
+	Continued bool // The line was split and continues in the next record.
+	Truncated bool // The line was truncated and the rest dropped.
+
+	// Fields holds structured data parsed from the line by a
+	// processor such as NewJSONParser, if any.
+	Fields map[string]any
+
+	// Dropped is set on a marker record standing in for this many
+	// records dropped from a full buffer; Line says as much.
+	// Markers bypass the processors.
+	Dropped int
+}
+
+// Text returns the line as kat prints it, with LineContinuedMarker
+// or LineTruncatedMarker appended to chunks of long lines.
+func (r *LogRecord) Text() string {
+	switch {
+	case r.Continued:
+		return r.Line + LineContinuedMarker
+	case r.Truncated:
+		return r.Line + LineTruncatedMarker
+	default:
+		return r.Line
+	}
+}
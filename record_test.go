@@ -0,0 +1,87 @@
+package kat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCopyLines_Records(t *testing.T) {
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	pod.Labels = map[string]string{"app": "web"}
+	pod.Spec.NodeName = "worker-3"
+
+	var (
+		records []LogRecord
+		lines   []string
+	)
+
+	k := New(nil, &StreamConfig{MaxLineLength: 64, LongLines: LongLineSplit}, &OutputConfig{
+		Sinks: []Sink{SinkFunc(func(record LogRecord) error {
+			records = append(records, record)
+			return nil
+		})},
+	}, &Callbacks{
+		OnLogLine: func(_, _, _, line string) {
+			lines = append(lines, line)
+		},
+	})
+
+	start := time.Now()
+
+	cs := newContainerStream(pod, "app", nil)
+	input := "2025-01-06T15:30:00.1Z short\n2025-01-06T15:30:00.2Z " + strings.Repeat("x", 60) + "\n"
+	if _, err := k.copyLines(cs, 2, strings.NewReader(input), &logCursor{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	first := records[0]
+	if first.Namespace != "default" || first.PodName != "web-0" || first.PodUID != pod.UID || first.Container != "app" {
+		t.Errorf("expected the record to identify the container, got %+v", first)
+	}
+
+	if first.NodeName != "worker-3" || !reflect.DeepEqual(first.Labels, pod.Labels) || first.Instance != 2 {
+		t.Errorf("expected the pod's node, labels and the instance, got %+v", first)
+	}
+
+	if want := time.Date(2025, 1, 6, 15, 30, 0, 100_000_000, time.UTC); !first.Timestamp.Equal(want) || first.Received.Before(start) {
+		t.Errorf("expected the kubelet timestamp and receive time, got %s and %s", first.Timestamp, first.Received)
+	}
+
+	if first.Line != "short" || first.Continued || first.Truncated {
+		t.Errorf("expected a whole short line, got %+v", first)
+	}
+
+	// Every chunk of a split line carries the line's timestamp.
+	if !records[1].Continued || records[2].Continued || !records[1].Timestamp.Equal(records[2].Timestamp) {
+		t.Errorf("expected a split line with one timestamp, got %+v and %+v", records[1], records[2])
+	}
+
+	if expected := []string{"short", records[1].Line + LineContinuedMarker, records[2].Line}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected OnLogLine to receive %q, got %q", expected, lines)
+	}
+}
+
+func TestLogRecord_Text(t *testing.T) {
+	tests := []struct {
+		record   LogRecord
+		expected string
+	}{
+		{record: LogRecord{Line: "one"}, expected: "one"},
+		{record: LogRecord{Line: "one", Continued: true}, expected: "one" + LineContinuedMarker},
+		{record: LogRecord{Line: "one", Truncated: true}, expected: "one" + LineTruncatedMarker},
+	}
+
+	for _, tt := range tests {
+		if text := tt.record.Text(); text != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, text)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package kat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// RetryPolicy controls how interrupted log streams are reconnected.
+// The delay between consecutive attempts doubles from InitialDelay
+// up to MaxDelay. The attempt count is reset whenever a reconnected
+// stream delivers new lines.
+type RetryPolicy struct {
+	MaxRetries   int           // Consecutive retries before giving up; negative retries forever.
+	InitialDelay time.Duration // Delay before the first retry; zero selects 100ms.
+	MaxDelay     time.Duration // Upper bound on the delay between retries; zero selects 30s.
+}
+
+const (
+	defaultRetryInitialDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay     = 30 * time.Second
+)
+
+// DefaultRetryPolicy returns the policy used when none is
+// configured: retry for as long as the error is retryable, backing
+// off to one attempt every 30 seconds.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:   -1,
+		InitialDelay: defaultRetryInitialDelay,
+		MaxDelay:     defaultRetryMaxDelay,
+	}
+}
+
+// allows reports whether the policy permits the given retry, where
+// the first retry is attempt 1.
+func (p *RetryPolicy) allows(attempt int) bool {
+	return p.MaxRetries < 0 || attempt <= p.MaxRetries
+}
+
+// delay returns the jittered delay before the given retry. Doubling
+// stops at the maximum delay, so that it cannot overflow however
+// many attempts are made.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d, maxDelay := p.InitialDelay, p.MaxDelay
+	if d <= 0 {
+		d = defaultRetryInitialDelay
+	}
+
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d = min(d, maxDelay/2) * 2
+	}
+
+	return wait.Jitter(min(d, maxDelay), 0.1)
+}
+
+// errorClass classifies errors from log and pod requests to decide
+// whether they are worth retrying.
+type errorClass int
+
+const (
+	errorUnknown    errorClass = iota // Unrecognised; assumed transient.
+	errorCanceled                     // The stream was cancelled.
+	errorNotFound                     // The pod or container no longer exists.
+	errorForbidden                    // Access is denied.
+	errorNotReady                     // The container has not started yet.
+	errorEOF                          // The connection ended prematurely.
+	errorNetwork                      // The connection failed or was reset.
+	errorServer                       // The server failed or asked us to back off.
+	errorBadRequest                   // The request was rejected as invalid.
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errorCanceled:
+		return "canceled"
+	case errorNotFound:
+		return "not found"
+	case errorForbidden:
+		return "forbidden"
+	case errorNotReady:
+		return "container not ready"
+	case errorEOF:
+		return "unexpected EOF"
+	case errorNetwork:
+		return "network error"
+	case errorServer:
+		return "server error"
+	case errorBadRequest:
+		return "bad request"
+	default:
+		return "unknown error"
+	}
+}
+
+// retryable reports whether an error of this class may go away if
+// the request is repeated.
+func (c errorClass) retryable() bool {
+	switch c {
+	case errorCanceled, errorNotFound, errorForbidden, errorBadRequest:
+		return false
+	default:
+		return true
+	}
+}
+
+// classifyError determines the class of an error returned by a log
+// or pod request.
+func classifyError(err error) errorClass {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorCanceled
+	case apierrors.IsNotFound(err), apierrors.IsGone(err):
+		return errorNotFound
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return errorForbidden
+	case apierrors.IsBadRequest(err):
+		return classifyBadRequest(err)
+	case apierrors.IsTooManyRequests(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return errorServer
+	case utilnet.IsConnectionReset(err), utilnet.IsConnectionRefused(err):
+		return errorNetwork
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), utilnet.IsProbableEOF(err):
+		return errorEOF
+	case errors.As(err, &netErr):
+		return errorNetwork
+	default:
+		return errorUnknown
+	}
+}
+
+// classifyBadRequest distinguishes the bad requests the kubelet
+// returns for containers that have not started, or whose previous
+// instance is gone, from other invalid requests.
+func classifyBadRequest(err error) errorClass {
+	message := err.Error()
+
+	switch {
+	case strings.Contains(message, "waiting to start"),
+		strings.Contains(message, "ContainerCreating"),
+		strings.Contains(message, "PodInitializing"):
+		return errorNotReady
+	case strings.Contains(message, "not found"):
+		return errorNotFound
+	default:
+		return errorBadRequest
+	}
+}
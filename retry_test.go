@@ -0,0 +1,195 @@
+package kat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRetryPolicy_Allows(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		attempt    int
+		expected   bool
+	}{
+		{name: "no retries", maxRetries: 0, attempt: 1, expected: false},
+		{name: "within limit", maxRetries: 3, attempt: 3, expected: true},
+		{name: "beyond limit", maxRetries: 3, attempt: 4, expected: false},
+		{name: "unlimited", maxRetries: -1, attempt: 1000, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &RetryPolicy{MaxRetries: tt.maxRetries}
+			if got := policy.allows(tt.attempt); got != tt.expected {
+				t.Errorf("expected allows(%d)=%v, got %v", tt.attempt, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := &RetryPolicy{
+		MaxRetries:   -1,
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+	}
+
+	tests := []struct {
+		attempt int
+		minimum time.Duration
+	}{
+		{attempt: 1, minimum: time.Second},
+		{attempt: 2, minimum: 2 * time.Second},
+		{attempt: 4, minimum: 8 * time.Second},
+		{attempt: 5, minimum: 10 * time.Second},
+		{attempt: 100, minimum: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			// Jitter adds up to 10%.
+			got := policy.delay(tt.attempt)
+			if got < tt.minimum || got > tt.minimum+tt.minimum/10 {
+				t.Errorf("expected delay in [%v, %v], got %v", tt.minimum, tt.minimum+tt.minimum/10, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_DelayLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		minimum time.Duration
+		maximum time.Duration
+	}{
+		{
+			name:    "no maximum",
+			policy:  RetryPolicy{InitialDelay: time.Second},
+			minimum: 30 * time.Second,
+			maximum: 33 * time.Second,
+		},
+		{
+			name:    "no initial delay",
+			policy:  RetryPolicy{MaxDelay: time.Minute},
+			minimum: time.Minute,
+			maximum: 66 * time.Second,
+		},
+		{
+			name:    "maximum near overflow",
+			policy:  RetryPolicy{InitialDelay: time.Second, MaxDelay: math.MaxInt64 / 2},
+			minimum: time.Second,
+			maximum: math.MaxInt64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, attempt := range []int{30, 38, 64, 100, 1000} {
+				got := tt.policy.delay(attempt)
+				if got < tt.minimum || got > tt.maximum {
+					t.Errorf("attempt %d: expected delay in [%v, %v], got %v", attempt, tt.minimum, tt.maximum, got)
+				}
+			}
+		})
+	}
+
+	if got := (&RetryPolicy{}).delay(1); got < 100*time.Millisecond || got > 110*time.Millisecond {
+		t.Errorf("expected the first delay to default to 100ms, got %v", got)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name      string
+		err       error
+		expected  errorClass
+		retryable bool
+	}{
+		{
+			name:     "canceled",
+			err:      fmt.Errorf("stream: %w", context.Canceled),
+			expected: errorCanceled,
+		},
+		{
+			name:     "pod not found",
+			err:      apierrors.NewNotFound(pods, "web-0"),
+			expected: errorNotFound,
+		},
+		{
+			name:     "forbidden",
+			err:      apierrors.NewForbidden(pods, "web-0", fmt.Errorf("no")),
+			expected: errorForbidden,
+		},
+		{
+			name:     "unauthorized",
+			err:      apierrors.NewUnauthorized("expired"),
+			expected: errorForbidden,
+		},
+		{
+			name:      "container not ready",
+			err:       apierrors.NewBadRequest(`container "app" in pod "web-0" is waiting to start: ContainerCreating`),
+			expected:  errorNotReady,
+			retryable: true,
+		},
+		{
+			name:     "previous instance not found",
+			err:      apierrors.NewBadRequest(`previous terminated container "app" in pod "web-0" not found`),
+			expected: errorNotFound,
+		},
+		{
+			name:     "other bad request",
+			err:      apierrors.NewBadRequest("invalid"),
+			expected: errorBadRequest,
+		},
+		{
+			name:      "throttled",
+			err:       apierrors.NewTooManyRequests("slow down", 1),
+			expected:  errorServer,
+			retryable: true,
+		},
+		{
+			name:      "unexpected EOF",
+			err:       io.ErrUnexpectedEOF,
+			expected:  errorEOF,
+			retryable: true,
+		},
+		{
+			name:      "connection reset",
+			err:       &net.OpError{Op: "read", Err: syscall.ECONNRESET},
+			expected:  errorNetwork,
+			retryable: true,
+		},
+		{
+			name:      "unknown",
+			err:       fmt.Errorf("something odd"),
+			expected:  errorUnknown,
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := classifyError(tt.err)
+			if class != tt.expected {
+				t.Errorf("expected class %v, got %v", tt.expected, class)
+			}
+
+			if class.retryable() != tt.retryable {
+				t.Errorf("expected retryable=%v, got %v", tt.retryable, class.retryable())
+			}
+		})
+	}
+}
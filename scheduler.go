@@ -0,0 +1,249 @@
+package kat
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AdmissionPolicy limits how many containers are streamed at once
+// and how quickly streams are started, so that watching a large
+// number of pods does not open thousands of log requests in a burst.
+// Streams waiting for admission are started in priority order:
+// containers whose instance failed or replaced a failed one first,
+// then the most recently started.
+type AdmissionPolicy struct {
+	MaxStreams int     // Containers followed at once; zero or negative is unlimited.
+	StartRate  float64 // Streams started per second; zero or negative is unlimited.
+	StartBurst int     // Streams that may start at once within StartRate; at least one.
+}
+
+// StreamStats counts container streams by scheduling state, and
+// the records they have dropped.
+type StreamStats struct {
+	Active int // Containers being followed.
+	Queued int // Containers waiting to be admitted.
+
+	// Dropped counts the records dropped from full output buffers
+	// since streaming began.
+	Dropped int64
+}
+
+// streamPriority orders streams waiting for admission.
+type streamPriority struct {
+	erroring  bool      // The instance failed, or replaced one that did.
+	startedAt time.Time // When the instance started, if known.
+}
+
+// before reports whether p should be admitted ahead of other.
+func (p streamPriority) before(other streamPriority) bool {
+	if p.erroring != other.erroring {
+		return p.erroring
+	}
+
+	return p.startedAt.After(other.startedAt)
+}
+
+// containerPriority returns the admission priority of the instance
+// of a container described by status.
+func containerPriority(status corev1.ContainerStatus) streamPriority {
+	var priority streamPriority
+
+	switch state := status.State; {
+	case state.Running != nil:
+		priority.startedAt = state.Running.StartedAt.Time
+	case state.Terminated != nil:
+		priority.startedAt = state.Terminated.StartedAt.Time
+		priority.erroring = state.Terminated.ExitCode != 0
+	}
+
+	if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.ExitCode != 0 {
+		priority.erroring = true
+	}
+
+	return priority
+}
+
+// streamTicket is a stream's place in the admission queue.
+type streamTicket struct {
+	priority streamPriority
+	seq      uint64 // Queue order among equal priorities.
+	index    int    // Position in the heap.
+	admitted chan struct{}
+}
+
+// streamQueue is a heap of tickets, highest priority first.
+type streamQueue []*streamTicket
+
+func (q streamQueue) Len() int { return len(q) }
+
+func (q streamQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority.before(q[j].priority)
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q streamQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *streamQueue) Push(x any) {
+	ticket := x.(*streamTicket)
+	ticket.index = len(*q)
+	*q = append(*q, ticket)
+}
+
+func (q *streamQueue) Pop() any {
+	old := *q
+	ticket := old[len(old)-1]
+	old[len(old)-1] = nil
+	ticket.index = -1
+	*q = old[:len(old)-1]
+
+	return ticket
+}
+
+// streamScheduler admits container streams according to an
+// admission policy. It has no goroutine of its own: streams are
+// admitted as they ask and as others finish, and a timer resumes
+// admission when the start rate or the throttle holds it back.
+type streamScheduler struct {
+	maxStreams int
+	startRate  float64
+	limiter    *rate.Limiter // Nil if starts are not rate limited.
+	throttle   *Throttle     // Slows and pauses admission (optional).
+
+	mu      sync.Mutex
+	active  int
+	queue   streamQueue
+	seq     uint64
+	resumer *time.Timer // Pending admission held back by the limiter.
+}
+
+func newStreamScheduler(policy *AdmissionPolicy, throttle *Throttle) *streamScheduler {
+	s := &streamScheduler{throttle: throttle}
+
+	if policy == nil {
+		return s
+	}
+
+	s.maxStreams = policy.MaxStreams
+
+	if policy.StartRate > 0 {
+		s.startRate = policy.StartRate
+		s.limiter = rate.NewLimiter(rate.Limit(policy.StartRate), max(policy.StartBurst, 1))
+	}
+
+	return s
+}
+
+// acquire waits for the stream to be admitted, returning a function
+// that must be called once the stream no longer needs its place. It
+// fails only if ctx is done first.
+func (s *streamScheduler) acquire(ctx context.Context, priority streamPriority) (release func(), err error) {
+	ticket := &streamTicket{
+		priority: priority,
+		admitted: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.seq++
+	ticket.seq = s.seq
+	heap.Push(&s.queue, ticket)
+	s.admit()
+	s.mu.Unlock()
+
+	select {
+	case <-ticket.admitted:
+		return s.release, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ticket.index < 0 {
+		// Admitted as ctx was done; give the place back.
+		s.active--
+		s.admit()
+	} else {
+		heap.Remove(&s.queue, ticket.index)
+	}
+
+	return nil, ctx.Err()
+}
+
+func (s *streamScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	s.admit()
+}
+
+// admit admits waiting streams, highest priority first, for as long
+// as the policy allows. While the API server is throttling requests,
+// admission pauses for as long as requests are held back and the
+// start rate is reduced in proportion to the request rate. Called
+// with mu held.
+func (s *streamScheduler) admit() {
+	if s.queue.Len() == 0 {
+		return
+	}
+
+	if s.throttle != nil {
+		if pause := s.throttle.pause(); pause > 0 {
+			s.resumeAfter(pause)
+			return
+		}
+	}
+
+	if s.limiter != nil {
+		s.limiter.SetLimit(rate.Limit(s.startRate * s.throttle.fraction()))
+	}
+
+	for s.queue.Len() > 0 && (s.maxStreams <= 0 || s.active < s.maxStreams) {
+		if s.limiter != nil && !s.limiter.Allow() {
+			s.resumeAfter(time.Duration(float64(time.Second) / float64(s.limiter.Limit())))
+			return
+		}
+
+		ticket := heap.Pop(&s.queue).(*streamTicket)
+		s.active++
+		close(ticket.admitted)
+	}
+}
+
+// resumeAfter arranges for admission to resume after delay, unless
+// it is already due to. Called with mu held.
+func (s *streamScheduler) resumeAfter(delay time.Duration) {
+	if s.resumer != nil {
+		return
+	}
+
+	s.resumer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.resumer = nil
+		s.admit()
+	})
+}
+
+func (s *streamScheduler) stats() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return StreamStats{
+		Active: s.active,
+		Queued: s.queue.Len(),
+	}
+}
@@ -0,0 +1,250 @@
+package kat
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// admitted acquires a place from the scheduler in the background,
+// sending the release function once the stream is admitted.
+func admitted(ctx context.Context, s *streamScheduler, priority streamPriority) <-chan func() {
+	ch := make(chan func(), 1)
+
+	go func() {
+		if release, err := s.acquire(ctx, priority); err == nil {
+			ch <- release
+		}
+	}()
+
+	return ch
+}
+
+func TestStreamScheduler_MaxStreams(t *testing.T) {
+	s := newStreamScheduler(&AdmissionPolicy{MaxStreams: 2}, nil)
+	ctx := context.Background()
+
+	first := admitted(ctx, s, streamPriority{})
+	second := admitted(ctx, s, streamPriority{})
+
+	var releases []func()
+	for _, ch := range []<-chan func(){first, second} {
+		select {
+		case release := <-ch:
+			releases = append(releases, release)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for admission")
+		}
+	}
+
+	third := admitted(ctx, s, streamPriority{})
+	waitFor(t, "third stream to queue", func() bool { return s.stats() == StreamStats{Active: 2, Queued: 1} })
+
+	releases[0]()
+
+	select {
+	case release := <-third:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the queued stream to be admitted once a place was released")
+	}
+
+	releases[1]()
+
+	if stats := s.stats(); stats != (StreamStats{}) {
+		t.Errorf("expected no active or queued streams, got %+v", stats)
+	}
+}
+
+func TestStreamScheduler_Priority(t *testing.T) {
+	s := newStreamScheduler(&AdmissionPolicy{MaxStreams: 1}, nil)
+	ctx := context.Background()
+
+	hold, err := s.acquire(ctx, streamPriority{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	priorities := map[string]streamPriority{
+		"old":      {startedAt: now.Add(-time.Hour)},
+		"new":      {startedAt: now},
+		"erroring": {erroring: true, startedAt: now.Add(-2 * time.Hour)},
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+
+	for _, name := range []string{"old", "new", "erroring"} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			release, err := s.acquire(ctx, priorities[name])
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+
+			release()
+		}()
+	}
+
+	waitFor(t, "streams to queue", func() bool { return s.stats().Queued == 3 })
+	hold()
+	wg.Wait()
+
+	if expected := []string{"erroring", "new", "old"}; !slices.Equal(order, expected) {
+		t.Errorf("expected admission order %v, got %v", expected, order)
+	}
+}
+
+func TestStreamScheduler_Cancelled(t *testing.T) {
+	s := newStreamScheduler(&AdmissionPolicy{MaxStreams: 1}, nil)
+
+	hold, err := s.acquire(context.Background(), streamPriority{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer hold()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.acquire(ctx, streamPriority{})
+		errCh <- err
+	}()
+
+	waitFor(t, "stream to queue", func() bool { return s.stats().Queued == 1 })
+	cancel()
+
+	if err := <-errCh; err == nil {
+		t.Fatal("expected an error for a cancelled stream")
+	}
+
+	if stats := s.stats(); stats != (StreamStats{Active: 1}) {
+		t.Errorf("expected the cancelled stream to leave the queue, got %+v", stats)
+	}
+}
+
+func TestStreamScheduler_StartRate(t *testing.T) {
+	s := newStreamScheduler(&AdmissionPolicy{StartRate: 20, StartBurst: 1}, nil)
+	ctx := context.Background()
+
+	start := time.Now()
+
+	for range 3 {
+		release, err := s.acquire(ctx, streamPriority{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		release()
+	}
+
+	// The first stream starts at once and the others 50ms apart.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected starts to be staggered, took %s", elapsed)
+	}
+}
+
+func TestContainerPriority(t *testing.T) {
+	startedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name     string
+		status   corev1.ContainerStatus
+		expected streamPriority
+	}{
+		{
+			name: "running",
+			status: corev1.ContainerStatus{
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: startedAt}},
+			},
+			expected: streamPriority{startedAt: startedAt.Time},
+		},
+		{
+			name: "restarted after failing",
+			status: corev1.ContainerStatus{
+				RestartCount:         1,
+				State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: startedAt}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+			},
+			expected: streamPriority{erroring: true, startedAt: startedAt.Time},
+		},
+		{
+			name: "restarted after succeeding",
+			status: corev1.ContainerStatus{
+				RestartCount:         1,
+				State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: startedAt}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			},
+			expected: streamPriority{startedAt: startedAt.Time},
+		},
+		{
+			name: "failed",
+			status: corev1.ContainerStatus{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, StartedAt: startedAt}},
+			},
+			expected: streamPriority{erroring: true, startedAt: startedAt.Time},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if priority := containerPriority(tt.status); priority != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, priority)
+			}
+		})
+	}
+}
+
+func TestStartStreaming_MaxStreams(t *testing.T) {
+	clientset, _ := newClientset(
+		newPod("default", "web-0", corev1.PodRunning, "app"),
+		newPod("default", "web-1", corev1.PodRunning, "app"),
+		newPod("default", "web-2", corev1.PodRunning, "app"),
+	)
+
+	rec := &recorder{}
+	callbacks := rec.callbacks()
+
+	var k *Kat
+
+	onStart := callbacks.OnStreamStart
+	callbacks.OnStreamStart = func(namespace, podName, containerName string) {
+		if stats := k.StreamStats(); stats.Active > 1 {
+			t.Errorf("expected at most one active stream, got %+v", stats)
+		}
+
+		onStart(namespace, podName, containerName)
+	}
+
+	k = New(clientset, &StreamConfig{
+		Retry:     noRetries(),
+		Admission: &AdmissionPolicy{MaxStreams: 1},
+	}, &OutputConfig{}, callbacks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreaming(ctx, []string{"default"}, time.Minute)
+
+	for _, pod := range []string{"web-0", "web-1", "web-2"} {
+		waitFor(t, pod+" stream", func() bool { return rec.hasStop("default/" + pod + ":app") })
+	}
+}
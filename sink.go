@@ -0,0 +1,447 @@
+package kat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Sink receives log records. Records from one container are
+// delivered in order from the goroutine streaming it; records from
+// different containers are delivered concurrently. An error is
+// reported through OnError and does not stop the stream or affect
+// the other sinks.
+//
+// Sinks that also implement StreamSink are told when each container
+// instance begins and ends, and sinks that implement io.Closer are
+// closed by StopStreaming, to flush their output and release their
+// resources.
+type Sink interface {
+	WriteRecord(record LogRecord) error
+}
+
+// StreamSink is a Sink that keeps state per container instance,
+// such as a file. OpenStream is called before the first record of
+// an instance is written; if it fails, the error is reported and
+// the instance's records are not written to the sink. CloseStream
+// is called once the records of the next instance begin or the
+// container's stream ends.
+type StreamSink interface {
+	Sink
+	OpenStream(stream StreamInfo) error
+	CloseStream(stream StreamInfo) error
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(record LogRecord) error
+
+func (f SinkFunc) WriteRecord(record LogRecord) error {
+	return f(record)
+}
+
+// callbackSink delivers records to Callbacks.OnLogLine.
+type callbackSink struct {
+	onLogLine func(namespace, podName, containerName, line string)
+}
+
+func (s callbackSink) WriteRecord(record LogRecord) error {
+	s.onLogLine(record.Namespace, record.PodName, record.Container, record.Text())
+	return nil
+}
+
+// ConsoleSink writes records to a writer as lines prefixed with the
+// container that logged them, as the kat command prints them.
+type ConsoleSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewConsoleSink returns a sink writing to w.
+func NewConsoleSink(w io.Writer) *ConsoleSink {
+	return &ConsoleSink{w: w}
+}
+
+func (s *ConsoleSink) WriteRecord(record LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "[%s/%s:%s] %s\n", record.Namespace, record.PodName, record.Container, record.Text())
+	return err
+}
+
+// FileSink writes the records of each container instance to a file
+// of its own, dir/namespace/pod/container.instance.txt. Existing
+// files are appended to rather than truncated so that a pod
+// recreated under the same name does not overwrite the output of
+// its predecessor. Records written after Close are discarded.
+//
+// Write, sync and open failures are handled under the options'
+// failure policy and reported through OnTeeFailed rather than as
+// errors from OpenStream or WriteRecord, once per failure: a disk
+// that has filled up fails every write.
+type FileSink struct {
+	dir       string
+	options   FileSinkOptions
+	callbacks *Callbacks // Reports files created and closed, and failures (optional).
+
+	mu     sync.RWMutex
+	files  map[fileKey]*sinkFile
+	closed bool
+
+	budgetMu sync.Mutex
+	written  int64           // Bytes written or reserved, for the budget.
+	counted  map[string]bool // Files whose existing bytes are counted.
+
+	failMu      sync.Mutex
+	stopped     bool      // Files are no longer written.
+	paused      bool      // Writing failed and is retried from pausedUntil.
+	pausedUntil time.Time // When writing is next tried.
+	lost        int       // Lines not written while paused.
+}
+
+// fileKey identifies the file of a container instance.
+type fileKey struct {
+	namespace string
+	podUID    types.UID
+	container string
+	instance  int32
+}
+
+// sinkFile is the file of a container instance, which is nil until
+// it has been opened. It and its lost count are owned by the
+// goroutine writing the container's records.
+type sinkFile struct {
+	*os.File
+	path string
+	lost int // Lines not written to the file while paused.
+}
+
+var _ StreamSink = (*FileSink)(nil)
+
+// NewFileSink returns a sink writing to files under dir, reporting
+// the files it creates and closes through the OnFileCreated and
+// OnFileClosed callbacks.
+func NewFileSink(dir string, options FileSinkOptions, callbacks *Callbacks) *FileSink {
+	return &FileSink{
+		dir:       dir,
+		options:   options,
+		callbacks: callbacks,
+		files:     make(map[fileKey]*sinkFile),
+		counted:   make(map[string]bool),
+	}
+}
+
+func streamFileKey(stream StreamInfo) fileKey {
+	return fileKey{
+		namespace: stream.Namespace,
+		podUID:    stream.PodUID,
+		container: stream.Container,
+		instance:  stream.Instance,
+	}
+}
+
+// OpenStream opens the file of a container instance, unless files
+// are no longer written. If it cannot be opened, or writing is
+// paused, it is opened when the instance's records are next written.
+func (s *FileSink) OpenStream(stream StreamInfo) error {
+	if s.isStopped() {
+		return nil
+	}
+
+	file := &sinkFile{
+		path: filepath.Join(s.dir, stream.Namespace, stream.PodName, fmt.Sprintf("%s.%d.txt", stream.Container, stream.Instance)),
+	}
+
+	if s.writable() {
+		_ = s.open(file)
+	}
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return s.closeFile(file)
+	}
+
+	s.files[streamFileKey(stream)] = file
+
+	s.mu.Unlock()
+
+	return nil
+}
+
+// open opens a file, handling failures under the failure policy.
+func (s *FileSink) open(file *sinkFile) error {
+	if err := os.MkdirAll(filepath.Dir(file.path), 0o755); err != nil {
+		err = fmt.Errorf("error creating directories for %s: %w", file.path, err)
+		s.fail(err)
+		return err
+	}
+
+	f, err := os.OpenFile(file.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		err = fmt.Errorf("error creating file %s: %w", file.path, err)
+		s.fail(err)
+		return err
+	}
+
+	file.File = f
+
+	if info, err := f.Stat(); err == nil {
+		s.countExisting(file.path, info.Size())
+	}
+
+	if s.callbacks != nil && s.callbacks.OnFileCreated != nil {
+		s.callbacks.OnFileCreated(file.path)
+	}
+
+	return nil
+}
+
+// CloseStream syncs and closes the file of a container instance.
+func (s *FileSink) CloseStream(stream StreamInfo) error {
+	s.mu.Lock()
+
+	file, ok := s.files[streamFileKey(stream)]
+	delete(s.files, streamFileKey(stream))
+
+	s.mu.Unlock()
+
+	// Close may have closed the file already.
+	if !ok {
+		return nil
+	}
+
+	if err := s.closeFile(file); err != nil {
+		s.fail(err)
+	}
+
+	return nil
+}
+
+func (s *FileSink) WriteRecord(record LogRecord) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[streamFileKey(record.StreamInfo)]
+	if !ok {
+		if s.closed || s.isStopped() {
+			return nil
+		}
+
+		return fmt.Errorf("no file open for %s/%s:%s instance %d", record.Namespace, record.PodName, record.Container, record.Instance)
+	}
+
+	if !s.writable() {
+		file.lost++
+		s.countLost()
+		return nil
+	}
+
+	if file.File == nil {
+		if err := s.open(file); err != nil {
+			file.lost++
+			s.countLost()
+			return nil
+		}
+	}
+
+	if file.lost > 0 {
+		if err := s.write(file, "["+countedLines(file.lost)+" not written]\n"); err != nil {
+			file.lost++
+			s.countLost()
+			return nil
+		}
+
+		file.lost = 0
+	}
+
+	if err := s.write(file, record.Text()+"\n"); err != nil {
+		file.lost++
+		s.countLost()
+		return nil
+	}
+
+	s.resumed()
+
+	return nil
+}
+
+// write writes text to a file within the budget, handling failures
+// under the failure policy. The bytes are reserved before writing so
+// that concurrent writers cannot overshoot the budget between them.
+func (s *FileSink) write(file *sinkFile, text string) error {
+	if written, ok := s.reserve(int64(len(text))); !ok {
+		err := fmt.Errorf("%w: %d bytes written to %s", ErrTeeBudgetExceeded, written, s.dir)
+		s.fail(err)
+		return err
+	}
+
+	n, err := file.WriteString(text)
+	if n < len(text) {
+		s.reserve(int64(n - len(text)))
+	}
+
+	if err != nil {
+		err = fmt.Errorf("write file %s: %w", file.path, err)
+		s.fail(err)
+		return err
+	}
+
+	return nil
+}
+
+// reserve adds n bytes to those written, unless that would exceed
+// the budget, returning the bytes written before.
+func (s *FileSink) reserve(n int64) (written int64, ok bool) {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	if n > 0 && s.options.MaxBytes > 0 && s.written+n > s.options.MaxBytes {
+		return s.written, false
+	}
+
+	written = s.written
+	s.written += n
+
+	return written, true
+}
+
+// countExisting counts the bytes a file held when it was first
+// opened against the budget, as it is appended to.
+func (s *FileSink) countExisting(path string, size int64) {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	if !s.counted[path] {
+		s.counted[path] = true
+		s.written += size
+	}
+}
+
+// writable reports whether files are being written: they are not
+// once stopped, nor while writing is paused.
+func (s *FileSink) writable() bool {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+
+	return !s.stopped && (!s.paused || !time.Now().Before(s.pausedUntil))
+}
+
+func (s *FileSink) isStopped() bool {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+
+	return s.stopped
+}
+
+func (s *FileSink) countLost() {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+
+	if s.paused {
+		s.lost++
+	}
+}
+
+// fail handles a failure under the failure policy, reporting it
+// unless writing had already stopped or paused.
+func (s *FileSink) fail(err error) {
+	action := s.options.OnFailure
+	if action == TeeFailureRetry && errors.Is(err, ErrTeeBudgetExceeded) {
+		action = TeeFailureContinue
+	}
+
+	s.failMu.Lock()
+
+	if s.stopped {
+		s.failMu.Unlock()
+		return
+	}
+
+	report := !s.paused
+
+	if action == TeeFailureRetry {
+		s.paused = true
+		s.pausedUntil = time.Now().Add(s.options.retryInterval())
+	} else {
+		s.stopped = true
+		report = true
+	}
+
+	s.failMu.Unlock()
+
+	if report && s.callbacks != nil && s.callbacks.OnTeeFailed != nil {
+		s.callbacks.OnTeeFailed(err, action)
+	}
+}
+
+// resumed records that a write succeeded, reporting the end of a
+// pause.
+func (s *FileSink) resumed() {
+	s.failMu.Lock()
+
+	if !s.paused {
+		s.failMu.Unlock()
+		return
+	}
+
+	lost := s.lost
+	s.paused = false
+	s.lost = 0
+
+	s.failMu.Unlock()
+
+	if s.callbacks != nil && s.callbacks.OnTeeResumed != nil {
+		s.callbacks.OnTeeResumed(lost)
+	}
+}
+
+// Close syncs and closes every open file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+
+	files := s.files
+	s.files = make(map[fileKey]*sinkFile)
+	s.closed = true
+
+	s.mu.Unlock()
+
+	var errs []error
+
+	for _, file := range files {
+		if err := s.closeFile(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *FileSink) closeFile(file *sinkFile) error {
+	if file.File == nil {
+		return nil
+	}
+
+	var errs []error
+
+	if err := file.Sync(); err != nil {
+		errs = append(errs, fmt.Errorf("sync file %s: %w", file.path, err))
+	}
+
+	if err := file.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close file %s: %w", file.path, err))
+	}
+
+	if s.callbacks != nil && s.callbacks.OnFileClosed != nil {
+		s.callbacks.OnFileClosed(file.path)
+	}
+
+	return errors.Join(errs...)
+}
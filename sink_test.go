@@ -0,0 +1,167 @@
+package kat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// lifecycleSink records the calls made to a StreamSink.
+type lifecycleSink struct {
+	events  []string
+	openErr error
+	closed  bool
+}
+
+func (s *lifecycleSink) OpenStream(stream StreamInfo) error {
+	s.events = append(s.events, fmt.Sprintf("open %d", stream.Instance))
+	return s.openErr
+}
+
+func (s *lifecycleSink) CloseStream(stream StreamInfo) error {
+	s.events = append(s.events, fmt.Sprintf("close %d", stream.Instance))
+	return nil
+}
+
+func (s *lifecycleSink) WriteRecord(record LogRecord) error {
+	s.events = append(s.events, fmt.Sprintf("write %d %s", record.Instance, record.Line))
+	return nil
+}
+
+func (s *lifecycleSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestDeliverRecord_StreamSinkLifecycle(t *testing.T) {
+	sink := &lifecycleSink{}
+	k := New(nil, nil, &OutputConfig{Sinks: []Sink{sink}}, nil)
+
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	for instance, input := range []string{"one\ntwo\n", "three\n"} {
+		if _, err := k.copyLines(cs, int32(instance), strings.NewReader(input), &logCursor{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	k.closeOutputs(cs)
+
+	expected := []string{"open 0", "write 0 one", "write 0 two", "close 0", "open 1", "write 1 three", "close 1"}
+	if !slices.Equal(sink.events, expected) {
+		t.Errorf("expected %q, got %q", expected, sink.events)
+	}
+
+	if err := k.StopStreaming(); err != nil || !sink.closed {
+		t.Errorf("expected StopStreaming to close the sink, got closed=%v err=%v", sink.closed, err)
+	}
+}
+
+func TestDeliverRecord_SinkErrors(t *testing.T) {
+	var (
+		errs      []error
+		delivered int
+	)
+
+	unopened := &lifecycleSink{openErr: errors.New("permission denied")}
+
+	k := New(nil, nil, &OutputConfig{
+		Sinks: []Sink{
+			unopened,
+			SinkFunc(func(LogRecord) error { return errors.New("disk full") }),
+			SinkFunc(func(LogRecord) error { delivered++; return nil }),
+		},
+	}, &Callbacks{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+	if _, err := k.copyLines(cs, 0, strings.NewReader("one\ntwo\n"), &logCursor{}); err != nil {
+		t.Fatalf("expected failing sinks not to stop the stream, got %v", err)
+	}
+
+	k.closeOutputs(cs)
+
+	// One failure to open and one per record written.
+	if len(errs) != 3 || delivered != 2 {
+		t.Errorf("expected 3 reported errors and 2 records delivered to the other sink, got %d and %d", len(errs), delivered)
+	}
+
+	if expected := []string{"open 0"}; !slices.Equal(unopened.events, expected) {
+		t.Errorf("expected a sink that failed to open to receive nothing more, got %q", unopened.events)
+	}
+}
+
+func TestConsoleSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewConsoleSink(&buf)
+
+	record := LogRecord{
+		StreamInfo: StreamInfo{Namespace: "default", PodName: "web-0", Container: "app"},
+		Line:       "hello",
+		Truncated:  true,
+	}
+
+	if err := sink.WriteRecord(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "[default/web-0:app] hello" + LineTruncatedMarker + "\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	rec := &recorder{}
+	sink := NewFileSink(dir, FileSinkOptions{}, rec.callbacks())
+
+	streams := []StreamInfo{
+		{Namespace: "default", PodName: "web-0", PodUID: "uid-1", Container: "app", Instance: 0},
+		{Namespace: "default", PodName: "web-0", PodUID: "uid-1", Container: "app", Instance: 1},
+	}
+
+	for _, stream := range streams {
+		if err := sink.OpenStream(stream); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := sink.WriteRecord(LogRecord{StreamInfo: stream, Line: "hello"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := sink.CloseStream(streams[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Records arriving as streams wind down are discarded.
+	if err := sink.WriteRecord(LogRecord{StreamInfo: streams[1], Line: "late"}); err != nil {
+		t.Errorf("expected a record written after Close to be discarded, got %v", err)
+	}
+
+	for _, name := range []string{"app.0.txt", "app.1.txt"} {
+		data, err := os.ReadFile(filepath.Join(dir, "default", "web-0", name))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+
+		if string(data) != "hello\n" {
+			t.Errorf("%s: expected content %q, got %q", name, "hello\n", string(data))
+		}
+	}
+
+	if len(rec.created) != 2 || len(rec.closed) != 2 {
+		t.Errorf("expected two files created and closed, got created=%v closed=%v", rec.created, rec.closed)
+	}
+}
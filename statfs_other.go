@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package kat
+
+import "errors"
+
+func freeDiskSpace(string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
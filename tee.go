@@ -0,0 +1,117 @@
+package kat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultTeeRetryInterval is how long writing log files pauses
+// after a failure under TeeFailureRetry when no interval is
+// configured.
+const DefaultTeeRetryInterval = 10 * time.Second
+
+// ErrTeeBudgetExceeded is reported when a FileSink has written as
+// many bytes as its budget allows.
+var ErrTeeBudgetExceeded = errors.New("disk budget exceeded")
+
+// TeeFailurePolicy selects what happens when writing log files
+// fails, such as when the disk is full.
+type TeeFailurePolicy int
+
+const (
+	TeeFailureContinue TeeFailurePolicy = iota // Stop writing files; other outputs carry on.
+	TeeFailureStop                             // Stop writing files and stop streaming.
+	TeeFailureRetry                            // Pause writing files and try again later.
+)
+
+// String returns the flag value naming the policy.
+func (p TeeFailurePolicy) String() string {
+	switch p {
+	case TeeFailureStop:
+		return "stop"
+	case TeeFailureRetry:
+		return "retry"
+	default:
+		return "continue"
+	}
+}
+
+// ParseTeeFailurePolicy parses the name of a tee failure policy, as
+// returned by TeeFailurePolicy.String.
+func ParseTeeFailurePolicy(name string) (TeeFailurePolicy, error) {
+	switch name {
+	case "continue":
+		return TeeFailureContinue, nil
+	case "stop":
+		return TeeFailureStop, nil
+	case "retry":
+		return TeeFailureRetry, nil
+	default:
+		return 0, fmt.Errorf("unknown tee failure policy %q (expected continue, stop or retry)", name)
+	}
+}
+
+// FileSinkOptions configures how a FileSink handles failures and how
+// much it may write.
+type FileSinkOptions struct {
+	// OnFailure selects what happens when writing, syncing or
+	// opening a file fails. Under TeeFailureRetry, lines are not
+	// written while writing is paused, and each file resumes with
+	// a marker saying how many of its lines were lost.
+	OnFailure TeeFailurePolicy
+
+	// RetryInterval is how long writing pauses under
+	// TeeFailureRetry. Zero selects DefaultTeeRetryInterval.
+	RetryInterval time.Duration
+
+	// MaxBytes bounds the bytes written, across all files,
+	// including those already in files that are appended to. Zero
+	// is unlimited. Reaching it stops writing files, and under
+	// TeeFailureStop streaming, as retrying cannot help.
+	MaxBytes int64
+}
+
+func (o *FileSinkOptions) retryInterval() time.Duration {
+	if o.RetryInterval <= 0 {
+		return DefaultTeeRetryInterval
+	}
+
+	return o.RetryInterval
+}
+
+// FreeDiskSpace returns the bytes available to unprivileged users on
+// the file system holding path, or its nearest existing parent if
+// path does not exist yet.
+func FreeDiskSpace(path string) (int64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+			break
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+
+		path = parent
+	}
+
+	return freeDiskSpace(path)
+}
+
+// countedLines returns "1 line" or "n lines".
+func countedLines(n int) string {
+	if n == 1 {
+		return "1 line"
+	}
+
+	return fmt.Sprintf("%d lines", n)
+}
@@ -0,0 +1,288 @@
+package kat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// teeFailures records the failures a FileSink reports.
+type teeFailures struct {
+	errs    []error
+	actions []TeeFailurePolicy
+	resumed []int
+}
+
+func (f *teeFailures) callbacks() *Callbacks {
+	return &Callbacks{
+		OnTeeFailed: func(err error, action TeeFailurePolicy) {
+			f.errs = append(f.errs, err)
+			f.actions = append(f.actions, action)
+		},
+		OnTeeResumed: func(lost int) {
+			f.resumed = append(f.resumed, lost)
+		},
+	}
+}
+
+var teeStream = StreamInfo{Namespace: "default", PodName: "web-0", PodUID: "uid-1", Container: "app"}
+
+// openFileSink returns a sink with the file of teeStream open.
+func openFileSink(t *testing.T, options FileSinkOptions, callbacks *Callbacks) (*FileSink, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	sink := NewFileSink(dir, options, callbacks)
+
+	if err := sink.OpenStream(teeStream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return sink, filepath.Join(dir, "default", "web-0", "app.0.txt")
+}
+
+// breakFile closes the file of teeStream behind the sink's back, so
+// that writing to it fails.
+func breakFile(sink *FileSink) {
+	sink.files[streamFileKey(teeStream)].File.Close()
+}
+
+func writeLines(t *testing.T, sink *FileSink, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		if err := sink.WriteRecord(LogRecord{StreamInfo: teeStream, Line: line}); err != nil {
+			t.Fatalf("expected failures to be handled by the policy, got %v", err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	return string(data)
+}
+
+func TestFileSink_WriteFailure(t *testing.T) {
+	for _, policy := range []TeeFailurePolicy{TeeFailureContinue, TeeFailureStop} {
+		t.Run(policy.String(), func(t *testing.T) {
+			failures := &teeFailures{}
+			sink, _ := openFileSink(t, FileSinkOptions{OnFailure: policy}, failures.callbacks())
+
+			writeLines(t, sink, "one")
+			breakFile(sink)
+			writeLines(t, sink, "two", "three")
+
+			if len(failures.errs) != 1 || failures.actions[0] != policy {
+				t.Fatalf("expected one failure reported with action %s, got %v %v", policy, failures.errs, failures.actions)
+			}
+
+			// No more files are opened.
+			next := teeStream
+			next.Instance = 1
+
+			if err := sink.OpenStream(next); err != nil || len(sink.files) != 1 {
+				t.Errorf("expected no file to be opened once writing has stopped, got %d files, %v", len(sink.files), err)
+			}
+		})
+	}
+}
+
+func TestFileSink_Retry(t *testing.T) {
+	failures := &teeFailures{}
+	sink, path := openFileSink(t, FileSinkOptions{OnFailure: TeeFailureRetry, RetryInterval: 20 * time.Millisecond}, failures.callbacks())
+
+	breakFile(sink)
+	writeLines(t, sink, "one", "two")
+
+	if len(failures.errs) != 1 || failures.actions[0] != TeeFailureRetry {
+		t.Fatalf("expected one failure reported with action retry, got %v %v", failures.errs, failures.actions)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sink.files[streamFileKey(teeStream)].File = file
+
+	time.Sleep(30 * time.Millisecond)
+	writeLines(t, sink, "three")
+
+	if expected := "[2 lines not written]\nthree\n"; readFile(t, path) != expected {
+		t.Errorf("expected %q, got %q", expected, readFile(t, path))
+	}
+
+	if len(failures.resumed) != 1 || failures.resumed[0] != 2 {
+		t.Errorf("expected writing to resume with 2 lines lost, got %v", failures.resumed)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileSink_RetryOpen(t *testing.T) {
+	failures := &teeFailures{}
+	dir := t.TempDir()
+
+	// A file in place of the namespace directory fails the open.
+	blocker := filepath.Join(dir, "default")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sink := NewFileSink(dir, FileSinkOptions{OnFailure: TeeFailureRetry, RetryInterval: 20 * time.Millisecond}, failures.callbacks())
+
+	if err := sink.OpenStream(teeStream); err != nil {
+		t.Fatalf("expected the failure to be handled by the policy, got %v", err)
+	}
+
+	writeLines(t, sink, "one", "two")
+
+	if len(failures.errs) != 1 || failures.actions[0] != TeeFailureRetry {
+		t.Fatalf("expected one failure reported with action retry, got %v %v", failures.errs, failures.actions)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	writeLines(t, sink, "three")
+
+	path := filepath.Join(dir, "default", "web-0", "app.0.txt")
+	if expected := "[2 lines not written]\nthree\n"; readFile(t, path) != expected {
+		t.Errorf("expected %q, got %q", expected, readFile(t, path))
+	}
+
+	if len(failures.resumed) != 1 || failures.resumed[0] != 2 {
+		t.Errorf("expected writing to resume with 2 lines lost, got %v", failures.resumed)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileSink_MaxBytes(t *testing.T) {
+	failures := &teeFailures{}
+	sink, path := openFileSink(t, FileSinkOptions{OnFailure: TeeFailureRetry, MaxBytes: 10}, failures.callbacks())
+
+	writeLines(t, sink, "hello", "world!", "x")
+
+	if readFile(t, path) != "hello\n" {
+		t.Errorf("expected writing to stop at the budget, got %q", readFile(t, path))
+	}
+
+	// Retrying cannot free up the budget.
+	if len(failures.errs) != 1 || !errors.Is(failures.errs[0], ErrTeeBudgetExceeded) || failures.actions[0] != TeeFailureContinue {
+		t.Errorf("expected the budget to stop writing files, got %v %v", failures.errs, failures.actions)
+	}
+}
+
+func TestFileSink_MaxBytesExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "default", "web-0", "app.0.txt")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	failures := &teeFailures{}
+	sink := NewFileSink(dir, FileSinkOptions{MaxBytes: 10}, failures.callbacks())
+
+	if err := sink.OpenStream(teeStream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeLines(t, sink, "world!")
+
+	if readFile(t, path) != "hello\n" {
+		t.Errorf("expected the bytes already in the file to count against the budget, got %q", readFile(t, path))
+	}
+
+	if len(failures.errs) != 1 || !errors.Is(failures.errs[0], ErrTeeBudgetExceeded) {
+		t.Errorf("expected the budget to be reported exceeded, got %v", failures.errs)
+	}
+}
+
+func TestFileSink_MaxBytesConcurrent(t *testing.T) {
+	const budget = 100
+
+	dir := t.TempDir()
+	sink := NewFileSink(dir, FileSinkOptions{MaxBytes: budget}, nil)
+
+	var wg sync.WaitGroup
+
+	for _, container := range []string{"app", "sidecar", "proxy"} {
+		stream := teeStream
+		stream.Container = container
+
+		if err := sink.OpenStream(stream); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 50 {
+				if err := sink.WriteRecord(LogRecord{StreamInfo: stream, Line: "123456789"}); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	written := 0
+	for _, container := range []string{"app", "sidecar", "proxy"} {
+		written += len(readFile(t, filepath.Join(dir, "default", "web-0", container+".0.txt")))
+	}
+
+	if written != budget {
+		t.Errorf("expected exactly %d bytes written within the budget, got %d", budget, written)
+	}
+}
+
+func TestFreeDiskSpace(t *testing.T) {
+	free, err := FreeDiskSpace(filepath.Join(t.TempDir(), "not", "created"))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free disk space is not supported on this platform")
+	}
+
+	if err != nil || free <= 0 {
+		t.Errorf("expected the free space of the nearest existing directory, got %d, %v", free, err)
+	}
+}
+
+func TestParseTeeFailurePolicy(t *testing.T) {
+	for _, policy := range []TeeFailurePolicy{TeeFailureContinue, TeeFailureStop, TeeFailureRetry} {
+		if parsed, err := ParseTeeFailurePolicy(policy.String()); err != nil || parsed != policy {
+			t.Errorf("expected %s to round trip, got %v, %v", policy, parsed, err)
+		}
+	}
+
+	if _, err := ParseTeeFailurePolicy("ignore"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
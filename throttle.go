@@ -0,0 +1,285 @@
+package kat
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	flowcontrolv1 "k8s.io/api/flowcontrol/v1"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// throttleCooldown is the minimum time between reductions of
+	// the request rate, so that a burst of rejections of requests
+	// already in flight counts as one.
+	throttleCooldown = time.Second
+
+	// throttleRecoveryInterval is how often the request rate
+	// recovers by a tenth of its maximum once rejections stop.
+	throttleRecoveryInterval = 5 * time.Second
+
+	// defaultRetryAfter is how long requests are held back after a
+	// rejection that does not say how long to wait.
+	defaultRetryAfter = time.Second
+
+	// minThrottleQPS is the rate below which requests are not
+	// slowed further.
+	minThrottleQPS = 1
+)
+
+// Throttle adapts the rate of requests to the API server to the
+// server's responses. Install it as the RateLimiter of the client's
+// rest.Config and wrap the config's transport with WrapTransport so
+// that it sees every response.
+//
+// Whenever the API server rejects a request as too many, whether
+// from its max-in-flight limit or from API Priority and Fairness,
+// the request rate is halved and all requests are held back for the
+// period given by the response's Retry-After header. The rate then
+// recovers step by step to its maximum while no more requests are
+// rejected. Stream admission slows down and pauses with it, if the
+// throttle is also set in the StreamConfig.
+type Throttle struct {
+	maxQPS   float64
+	maxBurst int
+	limiter  *rate.Limiter
+
+	mu          sync.Mutex
+	qps         float64
+	pausedUntil time.Time
+	reducedAt   time.Time
+	recovery    *time.Timer
+	subscribers []func(throttleEvent)
+}
+
+// throttleEvent describes a change to the rate of requests.
+type throttleEvent struct {
+	qps        float64       // The new request rate.
+	throttled  bool          // The rate was reduced; false once it has recovered fully.
+	retryAfter time.Duration // How long requests are held back, if reduced.
+	fairness   bool          // The rejection came from API Priority and Fairness.
+}
+
+var _ flowcontrol.RateLimiter = (*Throttle)(nil)
+
+// NewThrottle returns a throttle allowing up to qps requests per
+// second, which must be positive, with bursts of up to burst
+// requests.
+func NewThrottle(qps float64, burst int) *Throttle {
+	burst = max(burst, 1)
+
+	return &Throttle{
+		maxQPS:   qps,
+		maxBurst: burst,
+		limiter:  rate.NewLimiter(rate.Limit(qps), burst),
+		qps:      qps,
+	}
+}
+
+// WrapTransport wraps a client transport to report rejected
+// requests to the throttle. It is a transport.WrapperFunc, for use
+// with rest.Config.Wrap.
+func (t *Throttle) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &throttleObserver{rt: rt, throttle: t}
+}
+
+// TryAccept takes a request token if one is available immediately.
+func (t *Throttle) TryAccept() bool {
+	return t.pause() <= 0 && t.limiter.Allow()
+}
+
+// Accept waits for a request token.
+func (t *Throttle) Accept() {
+	_ = t.Wait(context.Background())
+}
+
+// Wait waits for a request token, or until ctx is done.
+func (t *Throttle) Wait(ctx context.Context) error {
+	for {
+		pause := t.pause()
+		if pause <= 0 {
+			break
+		}
+
+		timer := time.NewTimer(pause)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return t.limiter.Wait(ctx)
+}
+
+// QPS returns the current request rate.
+func (t *Throttle) QPS() float32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return float32(t.qps)
+}
+
+// Stop stops the rate recovering.
+func (t *Throttle) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.recovery != nil {
+		t.recovery.Stop()
+		t.recovery = nil
+	}
+}
+
+// pause returns how much longer requests are held back.
+func (t *Throttle) pause() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return time.Until(t.pausedUntil)
+}
+
+// fraction returns the current request rate as a fraction of the
+// maximum. A nil throttle never slows requests.
+func (t *Throttle) fraction() float64 {
+	if t == nil || t.maxQPS <= 0 {
+		return 1
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.qps / t.maxQPS
+}
+
+// subscribe arranges for fn to be called whenever the request rate
+// changes.
+func (t *Throttle) subscribe(fn func(throttleEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.subscribers = append(t.subscribers, fn)
+}
+
+// reject records a rejected request, holding requests back for
+// retryAfter and reducing the request rate.
+func (t *Throttle) reject(retryAfter time.Duration, fairness bool) {
+	now := time.Now()
+
+	t.mu.Lock()
+
+	if until := now.Add(retryAfter); until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+
+	if now.Sub(t.reducedAt) < throttleCooldown {
+		t.mu.Unlock()
+		return
+	}
+
+	t.reducedAt = now
+	t.setRate(max(t.qps/2, min(minThrottleQPS, t.maxQPS)))
+	t.scheduleRecovery()
+
+	event := throttleEvent{
+		qps:        t.qps,
+		throttled:  true,
+		retryAfter: retryAfter,
+		fairness:   fairness,
+	}
+	subscribers := t.subscribers
+
+	t.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// recoverStep raises the request rate by a step, reporting once it
+// has recovered fully.
+func (t *Throttle) recoverStep() {
+	t.mu.Lock()
+
+	t.recovery = nil
+
+	if time.Since(t.reducedAt) < throttleRecoveryInterval {
+		t.scheduleRecovery()
+		t.mu.Unlock()
+		return
+	}
+
+	t.setRate(min(t.qps+t.maxQPS/10, t.maxQPS))
+
+	if t.qps < t.maxQPS {
+		t.scheduleRecovery()
+		t.mu.Unlock()
+		return
+	}
+
+	event := throttleEvent{qps: t.qps}
+	subscribers := t.subscribers
+
+	t.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// scheduleRecovery schedules the next recovery step. Called with mu
+// held.
+func (t *Throttle) scheduleRecovery() {
+	if t.recovery == nil {
+		t.recovery = time.AfterFunc(throttleRecoveryInterval, t.recoverStep)
+	}
+}
+
+// setRate sets the request rate, scaling the burst with it. Called
+// with mu held.
+func (t *Throttle) setRate(qps float64) {
+	t.qps = qps
+	t.limiter.SetLimit(rate.Limit(qps))
+	t.limiter.SetBurst(max(int(float64(t.maxBurst)*t.qps/t.maxQPS), 1))
+}
+
+// throttleObserver reports responses rejecting requests as too many
+// to the throttle.
+type throttleObserver struct {
+	rt       http.RoundTripper
+	throttle *Throttle
+}
+
+func (o *throttleObserver) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		fairness := resp.Header.Get(flowcontrolv1.ResponseHeaderMatchedFlowSchemaUID) != ""
+		o.throttle.reject(retryAfter(resp), fairness)
+	}
+
+	return resp, err
+}
+
+// retryAfter returns the wait requested by a response's Retry-After
+// header, given in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return defaultRetryAfter
+}
@@ -0,0 +1,201 @@
+package kat
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	flowcontrolv1 "k8s.io/api/flowcontrol/v1"
+)
+
+// roundTripperFunc serves requests with a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestThrottle_Reject(t *testing.T) {
+	throttle := NewThrottle(100, 200)
+	defer throttle.Stop()
+
+	var events []throttleEvent
+	throttle.subscribe(func(event throttleEvent) {
+		events = append(events, event)
+	})
+
+	throttle.reject(time.Minute, false)
+
+	if qps := throttle.QPS(); qps != 50 {
+		t.Errorf("expected the rate to be halved to 50 QPS, got %v", qps)
+	}
+
+	if throttle.TryAccept() {
+		t.Errorf("expected requests to be held back for the Retry-After period")
+	}
+
+	// Rejections of requests already in flight count as one.
+	throttle.reject(time.Minute, false)
+
+	if qps := throttle.QPS(); qps != 50 {
+		t.Errorf("expected a single reduction within the cooldown, got %v QPS", qps)
+	}
+
+	if len(events) != 1 || !events[0].throttled || events[0].qps != 50 || events[0].retryAfter != time.Minute {
+		t.Errorf("expected a single throttled event at 50 QPS, got %+v", events)
+	}
+}
+
+func TestThrottle_Recovers(t *testing.T) {
+	throttle := NewThrottle(100, 200)
+	defer throttle.Stop()
+
+	var events []throttleEvent
+	throttle.subscribe(func(event throttleEvent) {
+		events = append(events, event)
+	})
+
+	throttle.reject(0, false)
+
+	throttle.mu.Lock()
+	throttle.reducedAt = time.Now().Add(-throttleRecoveryInterval)
+	throttle.mu.Unlock()
+
+	for _, expected := range []float32{60, 70, 80, 90, 100} {
+		throttle.recoverStep()
+
+		if qps := throttle.QPS(); qps != expected {
+			t.Fatalf("expected the rate to recover to %v QPS, got %v", expected, qps)
+		}
+	}
+
+	if len(events) != 2 || events[1].throttled || events[1].qps != 100 {
+		t.Errorf("expected a recovered event at 100 QPS, got %+v", events)
+	}
+
+	if !throttle.TryAccept() {
+		t.Errorf("expected requests to be accepted once recovered")
+	}
+}
+
+func TestThrottle_WrapTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		expected []throttleEvent
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+		},
+		{
+			name:     "too many requests",
+			status:   http.StatusTooManyRequests,
+			header:   http.Header{"Retry-After": {"3"}},
+			expected: []throttleEvent{{qps: 50, throttled: true, retryAfter: 3 * time.Second}},
+		},
+		{
+			name:   "priority and fairness",
+			status: http.StatusTooManyRequests,
+			header: func() http.Header {
+				header := http.Header{"Retry-After": {"1"}}
+				header.Set(flowcontrolv1.ResponseHeaderMatchedFlowSchemaUID, "uid-global-default")
+				return header
+			}(),
+			expected: []throttleEvent{{qps: 50, throttled: true, retryAfter: time.Second, fairness: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := NewThrottle(100, 200)
+			defer throttle.Stop()
+
+			var events []throttleEvent
+			throttle.subscribe(func(event throttleEvent) {
+				events = append(events, event)
+			})
+
+			rt := throttle.WrapTransport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: tt.status, Header: tt.header}, nil
+			}))
+
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/api/v1/pods", nil)
+			if _, err := rt.RoundTrip(req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(events) != len(tt.expected) || (len(events) > 0 && events[0] != tt.expected[0]) {
+				t.Errorf("expected events %+v, got %+v", tt.expected, events)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "5", expected: 5 * time.Second},
+		{value: "", expected: defaultRetryAfter},
+		{value: "0", expected: defaultRetryAfter},
+		{value: "soon", expected: defaultRetryAfter},
+		{value: "Mon, 02 Jan 2006 15:04:05 GMT", expected: defaultRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{"Retry-After": {tt.value}}}
+
+			if wait := retryAfter(resp); wait != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, wait)
+			}
+		})
+	}
+}
+
+func TestStreamScheduler_PausedWhileThrottled(t *testing.T) {
+	throttle := NewThrottle(100, 200)
+	defer throttle.Stop()
+
+	s := newStreamScheduler(&AdmissionPolicy{StartRate: 1000, StartBurst: 1000}, throttle)
+
+	throttle.reject(100*time.Millisecond, false)
+	start := time.Now()
+
+	release, err := s.acquire(context.Background(), streamPriority{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected admission to wait for the Retry-After period, took %s", elapsed)
+	}
+
+	if limit := s.limiter.Limit(); limit != 500 {
+		t.Errorf("expected the start rate to be halved with the request rate, got %v", limit)
+	}
+}
+
+func TestNew_ReportsThrottling(t *testing.T) {
+	throttle := NewThrottle(100, 200)
+	defer throttle.Stop()
+
+	var reported []float64
+
+	New(nil, &StreamConfig{Throttle: throttle}, &OutputConfig{}, &Callbacks{
+		OnThrottled: func(qps float64, retryAfter time.Duration, fairness bool) {
+			reported = append(reported, qps)
+		},
+	})
+
+	throttle.reject(time.Second, false)
+
+	if len(reported) != 1 || reported[0] != 50 {
+		t.Errorf("expected throttling at 50 QPS to be reported, got %v", reported)
+	}
+}
@@ -0,0 +1,104 @@
+package kat
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The informers hold every watched pod and Service in memory for as
+// long as kat runs, which with -A on a large cluster is most of its
+// footprint. Their transform functions reduce each object to the
+// fields kat reads before it is cached. They must be idempotent, as
+// the informers may pass them objects that are already reduced.
+
+// transformPod reduces a pod to its identity, labels and owners,
+// which select it, the node it runs on, which log records carry,
+// and the phase and container states that drive streaming.
+func transformPod(obj any) (any, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: reducedObjectMeta(&pod.ObjectMeta),
+		Spec: corev1.PodSpec{
+			NodeName: pod.Spec.NodeName,
+		},
+		Status: corev1.PodStatus{
+			Phase:                      pod.Status.Phase,
+			InitContainerStatuses:      reducedContainerStatuses(pod.Status.InitContainerStatuses),
+			ContainerStatuses:          reducedContainerStatuses(pod.Status.ContainerStatuses),
+			EphemeralContainerStatuses: reducedContainerStatuses(pod.Status.EphemeralContainerStatuses),
+		},
+	}, nil
+}
+
+// transformService reduces a Service to its identity and selector.
+func transformService(obj any) (any, error) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return obj, nil
+	}
+
+	return &corev1.Service{
+		ObjectMeta: reducedObjectMeta(&service.ObjectMeta),
+		Spec: corev1.ServiceSpec{
+			Selector: service.Spec.Selector,
+		},
+	}, nil
+}
+
+func reducedObjectMeta(meta *metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            meta.Name,
+		Namespace:       meta.Namespace,
+		UID:             meta.UID,
+		ResourceVersion: meta.ResourceVersion,
+		Labels:          meta.Labels,
+		OwnerReferences: meta.OwnerReferences,
+	}
+}
+
+// reducedContainerStatuses keeps the state of each container
+// instance and when its predecessor terminated, dropping images,
+// resources and termination messages.
+func reducedContainerStatuses(statuses []corev1.ContainerStatus) []corev1.ContainerStatus {
+	if statuses == nil {
+		return nil
+	}
+
+	reduced := make([]corev1.ContainerStatus, len(statuses))
+	for i, status := range statuses {
+		reduced[i] = corev1.ContainerStatus{
+			Name:                 status.Name,
+			RestartCount:         status.RestartCount,
+			State:                reducedContainerState(status.State),
+			LastTerminationState: reducedContainerState(status.LastTerminationState),
+		}
+	}
+
+	return reduced
+}
+
+func reducedContainerState(state corev1.ContainerState) corev1.ContainerState {
+	var reduced corev1.ContainerState
+
+	if state.Waiting != nil {
+		reduced.Waiting = &corev1.ContainerStateWaiting{Reason: state.Waiting.Reason}
+	}
+
+	if state.Running != nil {
+		reduced.Running = &corev1.ContainerStateRunning{StartedAt: state.Running.StartedAt}
+	}
+
+	if state.Terminated != nil {
+		reduced.Terminated = &corev1.ContainerStateTerminated{
+			ExitCode:   state.Terminated.ExitCode,
+			StartedAt:  state.Terminated.StartedAt,
+			FinishedAt: state.Terminated.FinishedAt,
+		}
+	}
+
+	return reduced
+}
@@ -0,0 +1,300 @@
+package kat
+
+import (
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestTransformPod(t *testing.T) {
+	finishedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	pod := newPod("default", "web-0", corev1.PodRunning, "app")
+	pod.Labels = map[string]string{"app": "web"}
+	pod.Annotations = map[string]string{"note": "dropped"}
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-abc", UID: "uid-rs"}}
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}
+	pod.Spec.NodeName = "worker-3"
+	pod.Status.PodIP = "10.0.0.1"
+	pod.Status.ContainerStatuses[0].Image = "registry.example.com/app:v1"
+	pod.Status.ContainerStatuses[0].RestartCount = 2
+	pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &corev1.ContainerStateTerminated{
+		ExitCode:   1,
+		Message:    "dropped",
+		FinishedAt: finishedAt,
+	}
+
+	obj, err := transformPod(pod)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reduced := obj.(*corev1.Pod)
+
+	if reduced.Name != pod.Name || reduced.Namespace != pod.Namespace || reduced.UID != pod.UID {
+		t.Errorf("expected the pod's identity to be kept, got %s/%s %s", reduced.Namespace, reduced.Name, reduced.UID)
+	}
+
+	if !reflect.DeepEqual(reduced.Labels, pod.Labels) || !reflect.DeepEqual(reduced.OwnerReferences, pod.OwnerReferences) {
+		t.Errorf("expected labels and owner references to be kept")
+	}
+
+	if reduced.Annotations != nil || reduced.ManagedFields != nil || !reflect.DeepEqual(reduced.Spec, corev1.PodSpec{NodeName: "worker-3"}) || reduced.Status.PodIP != "" {
+		t.Errorf("expected annotations, managed fields, the spec other than the node and unused status to be dropped")
+	}
+
+	status := reduced.Status.ContainerStatuses[0]
+	if status.Name != "app" || status.RestartCount != 2 || status.State.Running == nil || status.Image != "" {
+		t.Errorf("expected the container's name, restart count and state only, got %+v", status)
+	}
+
+	if terminated := status.LastTerminationState.Terminated; terminated == nil || !terminated.FinishedAt.Equal(&finishedAt) || terminated.Message != "" {
+		t.Errorf("expected the previous instance's finish time only, got %+v", terminated)
+	}
+
+	if again, _ := transformPod(reduced); !reflect.DeepEqual(again, reduced) {
+		t.Errorf("expected the transform to be idempotent")
+	}
+}
+
+func TestTransformService(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{"note": "dropped"}},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "web"},
+			Ports:    []corev1.ServicePort{{Port: 80}},
+		},
+	}
+
+	obj, err := transformService(service)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reduced := obj.(*corev1.Service)
+
+	if reduced.Name != "web" || reduced.Annotations != nil || !reflect.DeepEqual(reduced.Spec, corev1.ServiceSpec{Selector: service.Spec.Selector}) {
+		t.Errorf("expected the service's name and selector only, got %+v", reduced)
+	}
+}
+
+func TestTransform_OtherObjects(t *testing.T) {
+	other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	for name, transform := range map[string]func(any) (any, error){
+		"pod":     transformPod,
+		"service": transformService,
+	} {
+		if obj, err := transform(other); err != nil || obj != other {
+			t.Errorf("%s: expected other objects to be passed through, got %v, %v", name, obj, err)
+		}
+	}
+}
+
+// deploymentPod returns a pod as a Deployment creates it and the
+// kubelet reports it: an application container with a proxy sidecar,
+// with the managed fields, annotations, volumes and conditions the
+// API server returns.
+func deploymentPod() *corev1.Pod {
+	started := metav1.NewTime(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: "config", MountPath: "/etc/app"},
+		{Name: "kube-api-access-x7k2p", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount", ReadOnly: true},
+	}
+	probe := &corev1.Probe{
+		ProbeHandler:  corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt32(8080)}},
+		PeriodSeconds: 10,
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "payments",
+			Name:              "checkout-7d9f8b6c5-x2k4p",
+			GenerateName:      "checkout-7d9f8b6c5-",
+			UID:               "5f1c2a9e-8b3d-4c6f-9a1e-2d7b8c4f6e3a",
+			ResourceVersion:   "184467223",
+			CreationTimestamp: started,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":    "checkout",
+				"app.kubernetes.io/part-of": "payments",
+				"pod-template-hash":         "7d9f8b6c5",
+				"version":                   "v2",
+			},
+			Annotations: map[string]string{
+				"kubectl.kubernetes.io/restartedAt": "2026-01-02T03:00:00Z",
+				"prometheus.io/scrape":              "true",
+				"prometheus.io/port":                "9090",
+				"sidecar.example.com/status":        `{"initContainers":null,"containers":["proxy"],"volumes":["proxy-config","proxy-certs"],"imagePullSecrets":null,"revision":"default"}`,
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       "checkout-7d9f8b6c5",
+				UID:        "0c8e4b2a-6d1f-4e3a-8b9c-7f2e5a1d3c6b",
+			}},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:    "kube-controller-manager",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					APIVersion: "v1",
+					Time:       &started,
+					FieldsType: "FieldsV1",
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{".":{},"f:kubectl.kubernetes.io/restartedAt":{},"f:prometheus.io/port":{},"f:prometheus.io/scrape":{}},"f:generateName":{},"f:labels":{".":{},"f:app.kubernetes.io/name":{},"f:app.kubernetes.io/part-of":{},"f:pod-template-hash":{},"f:version":{}},"f:ownerReferences":{".":{},"k:{\"uid\":\"0c8e4b2a-6d1f-4e3a-8b9c-7f2e5a1d3c6b\"}":{}}},` +
+						`"f:spec":{"f:containers":{"k:{\"name\":\"app\"}":{".":{},"f:env":{".":{},"k:{\"name\":\"LOG_LEVEL\"}":{".":{},"f:name":{},"f:value":{}},"k:{\"name\":\"POD_NAME\"}":{".":{},"f:name":{},"f:valueFrom":{".":{},"f:fieldRef":{}}}},"f:image":{},"f:imagePullPolicy":{},"f:livenessProbe":{".":{},"f:failureThreshold":{},"f:httpGet":{".":{},"f:path":{},"f:port":{},"f:scheme":{}},"f:periodSeconds":{},"f:successThreshold":{},"f:timeoutSeconds":{}},"f:name":{},"f:ports":{".":{},"k:{\"containerPort\":8080,\"protocol\":\"TCP\"}":{".":{},"f:containerPort":{},"f:name":{},"f:protocol":{}}},"f:resources":{".":{},"f:limits":{".":{},"f:cpu":{},"f:memory":{}},"f:requests":{".":{},"f:cpu":{},"f:memory":{}}},"f:terminationMessagePath":{},"f:terminationMessagePolicy":{},"f:volumeMounts":{".":{},"k:{\"mountPath\":\"/etc/app\"}":{".":{},"f:mountPath":{},"f:name":{}}}}},"f:dnsPolicy":{},"f:enableServiceLinks":{},"f:restartPolicy":{},"f:schedulerName":{},"f:securityContext":{},"f:terminationGracePeriodSeconds":{},"f:volumes":{".":{},"k:{\"name\":\"config\"}":{".":{},"f:configMap":{".":{},"f:defaultMode":{},"f:name":{}},"f:name":{}}}}}`)},
+				},
+				{
+					Manager:     "kubelet",
+					Operation:   metav1.ManagedFieldsOperationUpdate,
+					APIVersion:  "v1",
+					Time:        &started,
+					FieldsType:  "FieldsV1",
+					Subresource: "status",
+					FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:status":{"f:conditions":{"k:{\"type\":\"ContainersReady\"}":{".":{},"f:lastProbeTime":{},"f:lastTransitionTime":{},"f:status":{},"f:type":{}},"k:{\"type\":\"Initialized\"}":{".":{},"f:lastProbeTime":{},"f:lastTransitionTime":{},"f:status":{},"f:type":{}},"k:{\"type\":\"PodReadyToStartContainers\"}":{".":{},"f:lastProbeTime":{},"f:lastTransitionTime":{},"f:status":{},"f:type":{}},"k:{\"type\":\"Ready\"}":{".":{},"f:lastProbeTime":{},"f:lastTransitionTime":{},"f:status":{},"f:type":{}}},"f:containerStatuses":{},"f:hostIP":{},"f:hostIPs":{},"f:phase":{},"f:podIP":{},"f:podIPs":{".":{},"k:{\"ip\":\"10.244.3.17\"}":{".":{},"f:ip":{}}},"f:startTime":{}}}`)},
+				},
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           "worker-3.eu-west-1.compute.internal",
+			ServiceAccountName: "checkout",
+			Containers: []corev1.Container{
+				{
+					Name:  "app",
+					Image: "registry.example.com/payments/checkout:2.14.3",
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP}},
+					Env: []corev1.EnvVar{
+						{Name: "LOG_LEVEL", Value: "info"},
+						{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+					},
+					Resources:                resources,
+					VolumeMounts:             mounts,
+					LivenessProbe:            probe,
+					ReadinessProbe:           probe,
+					TerminationMessagePath:   "/dev/termination-log",
+					TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+					ImagePullPolicy:          corev1.PullIfNotPresent,
+				},
+				{
+					Name:                     "proxy",
+					Image:                    "registry.example.com/mesh/proxy:1.22.1",
+					Args:                     []string{"proxy", "sidecar", "--domain", "$(POD_NAMESPACE).svc.cluster.local", "--log-level=warning"},
+					Resources:                resources,
+					VolumeMounts:             mounts,
+					ReadinessProbe:           probe,
+					TerminationMessagePath:   "/dev/termination-log",
+					TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+					ImagePullPolicy:          corev1.PullIfNotPresent,
+				},
+			},
+			Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "checkout-config"}}}},
+				{Name: "kube-api-access-x7k2p", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+					{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token", ExpirationSeconds: ptrTo(int64(3607))}},
+					{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"}, Items: []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}}}},
+				}}}},
+			},
+			Tolerations: []corev1.Toleration{
+				{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: ptrTo(int64(300))},
+				{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: ptrTo(int64(300))},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:     corev1.PodRunning,
+			HostIP:    "10.0.12.7",
+			PodIP:     "10.244.3.17",
+			PodIPs:    []corev1.PodIP{{IP: "10.244.3.17"}},
+			StartTime: &started,
+			QOSClass:  corev1.PodQOSBurstable,
+		},
+	}
+
+	for _, condition := range []corev1.PodConditionType{"PodReadyToStartContainers", corev1.PodInitialized, corev1.PodReady, corev1.ContainersReady, corev1.PodScheduled} {
+		pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: condition, Status: corev1.ConditionTrue, LastTransitionTime: started})
+	}
+
+	for _, container := range pod.Spec.Containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:        container.Name,
+			Image:       container.Image,
+			ImageID:     container.Image + "@sha256:9b2c4f1e7a3d5c8b6e0f2a4d7c9e1b3f5a8d0c2e4f6b8a1c3e5d7f9b2a4c6e8d0",
+			ContainerID: "containerd://3f8a1c5e7b9d2f4a6c8e0b1d3f5a7c9e2b4d6f8a0c1e3b5d7f9a2c4e6b8d0f1a3",
+			Ready:       true,
+			Started:     ptrTo(true),
+			State:       corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: started}},
+		})
+	}
+
+	return pod
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
+
+// BenchmarkTransformPod_Retained measures the heap an informer
+// retains per cached pod, with and without transformPod. Pods are
+// decoded from JSON, as the informer receives them, so that the
+// cached pods share no memory with each other.
+func BenchmarkTransformPod_Retained(b *testing.B) {
+	const pods = 1000
+
+	data, err := json.Marshal(deploymentPod())
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		transform func(any) (any, error)
+	}{
+		{name: "full"},
+		{name: "transformed", transform: transformPod},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			var retained uint64
+
+			for range b.N {
+				cache := make([]any, pods)
+
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				for i := range cache {
+					pod := &corev1.Pod{}
+					if err := json.Unmarshal(data, pod); err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+
+					cache[i] = pod
+
+					if tt.transform != nil {
+						if cache[i], err = tt.transform(pod); err != nil {
+							b.Fatalf("unexpected error: %v", err)
+						}
+					}
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(cache)
+
+				retained += after.HeapAlloc - before.HeapAlloc
+			}
+
+			b.ReportMetric(float64(retained)/float64(b.N*pods), "B/pod")
+			b.ReportMetric(float64(len(data)), "json-B")
+		})
+	}
+
+}
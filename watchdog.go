@@ -0,0 +1,143 @@
+package kat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// errStreamStalled is reported for a log request that was cancelled
+// by its watchdog because it delivered no data for the idle timeout.
+var errStreamStalled = errors.New("log stream stalled")
+
+// streamWatchdog monitors a single log request. Log requests that
+// pass through the API server to the kubelet occasionally hang
+// without the connection failing, so a request that delivers no
+// data for the idle timeout is cancelled to be re-established. The
+// watchdog also reports the container as quiet if it has logged
+// before but logs no new lines for the quiet period.
+type streamWatchdog struct {
+	k       *Kat
+	cs      *containerStream
+	cancel  context.CancelFunc
+	started bool
+
+	lastRead atomic.Int64 // UnixNano of the last data read.
+	stalled  atomic.Bool  // The request was cancelled for being idle.
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// startWatchdog starts monitoring a log request that is cancelled by
+// calling cancel. It does nothing if neither an idle timeout nor a
+// quiet period is configured.
+func (k *Kat) startWatchdog(cs *containerStream, cancel context.CancelFunc) *streamWatchdog {
+	w := &streamWatchdog{
+		k:       k,
+		cs:      cs,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	w.lastRead.Store(time.Now().UnixNano())
+
+	if interval := k.watchdogInterval(); interval > 0 {
+		w.started = true
+		go w.run(interval)
+	}
+
+	return w
+}
+
+// watchdogInterval returns how often the watchdog checks a request:
+// a quarter of the shorter of the idle timeout and quiet period, or
+// zero if neither is configured.
+func (k *Kat) watchdogInterval() time.Duration {
+	var shortest time.Duration
+
+	for _, d := range []time.Duration{k.streamConfig.IdleTimeout, k.streamConfig.QuietPeriod} {
+		if d > 0 && (shortest == 0 || d < shortest) {
+			shortest = d
+		}
+	}
+
+	return shortest / 4
+}
+
+func (w *streamWatchdog) run(interval time.Duration) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case now := <-ticker.C:
+			if w.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// check cancels the request if it has been idle for too long,
+// reporting whether it did so, and reports the container if it has
+// gone quiet.
+func (w *streamWatchdog) check(now time.Time) bool {
+	config := w.k.streamConfig
+
+	if lastLine := w.cs.lastLine.Load(); config.QuietPeriod > 0 && lastLine != 0 {
+		since := time.Unix(0, lastLine)
+		if now.Sub(since) >= config.QuietPeriod && w.cs.quiet.CompareAndSwap(false, true) {
+			if w.k.callbacks != nil && w.k.callbacks.OnContainerQuiet != nil {
+				w.k.callbacks.OnContainerQuiet(w.cs.namespace, w.cs.podName, w.cs.name, since)
+			}
+		}
+	}
+
+	if config.IdleTimeout > 0 && now.Sub(time.Unix(0, w.lastRead.Load())) >= config.IdleTimeout {
+		w.stalled.Store(true)
+		w.cancel()
+		return true
+	}
+
+	return false
+}
+
+// reader returns r wrapped to record when data was last read.
+func (w *streamWatchdog) reader(r io.Reader) io.Reader {
+	return &activityReader{r: r, lastRead: &w.lastRead}
+}
+
+// stop stops monitoring the request and reports whether it was
+// cancelled for being idle.
+func (w *streamWatchdog) stop() bool {
+	close(w.done)
+
+	if w.started {
+		<-w.stopped
+	}
+
+	return w.stalled.Load()
+}
+
+// activityReader records the time data was last read from a stream.
+type activityReader struct {
+	r        io.Reader
+	lastRead *atomic.Int64
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.lastRead.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
@@ -0,0 +1,95 @@
+package kat
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestStreamWatchdog_CancelsIdleRequest(t *testing.T) {
+	k := New(nil, &StreamConfig{IdleTimeout: 20 * time.Millisecond}, &OutputConfig{}, nil)
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+
+	r, w := io.Pipe()
+	defer w.Close()
+
+	watchdog := k.startWatchdog(cs, func() { r.CloseWithError(context.Canceled) })
+
+	// The read blocks until the watchdog cancels the request.
+	if _, err := io.ReadAll(watchdog.reader(r)); err == nil {
+		t.Errorf("expected the idle request to be cancelled")
+	}
+
+	if !watchdog.stop() {
+		t.Errorf("expected the request to be reported as stalled")
+	}
+}
+
+func TestStreamWatchdog_ActiveRequest(t *testing.T) {
+	k := New(nil, &StreamConfig{IdleTimeout: 50 * time.Millisecond}, &OutputConfig{}, nil)
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+
+	r, w := io.Pipe()
+
+	var cancelled atomic.Bool
+	watchdog := k.startWatchdog(cs, func() {
+		cancelled.Store(true)
+		r.CloseWithError(context.Canceled)
+	})
+
+	go func() {
+		defer w.Close()
+
+		for range 20 {
+			if _, err := w.Write([]byte("line\n")); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	if _, err := io.ReadAll(watchdog.reader(r)); err != nil {
+		t.Errorf("expected the request to complete, got %v", err)
+	}
+
+	if watchdog.stop() || cancelled.Load() {
+		t.Errorf("expected an active request not to be cancelled")
+	}
+}
+
+func TestStreamWatchdog_QuietContainer(t *testing.T) {
+	var quiet atomic.Int32
+
+	callbacks := &Callbacks{
+		OnContainerQuiet: func(_, _, _ string, _ time.Time) {
+			quiet.Add(1)
+		},
+	}
+
+	k := New(nil, &StreamConfig{QuietPeriod: 20 * time.Millisecond}, &OutputConfig{}, callbacks)
+	cs := newContainerStream(newPod("default", "web-0", corev1.PodRunning, "app"), "app", nil)
+
+	// A container that has never logged is not reported.
+	watchdog := k.startWatchdog(cs, func() {})
+	time.Sleep(60 * time.Millisecond)
+	watchdog.stop()
+
+	if n := quiet.Load(); n != 0 {
+		t.Fatalf("expected no quiet reports before the first line, got %d", n)
+	}
+
+	cs.lastLine.Store(time.Now().UnixNano())
+
+	watchdog = k.startWatchdog(cs, func() {})
+	waitFor(t, "quiet report", func() bool { return quiet.Load() > 0 })
+	time.Sleep(60 * time.Millisecond)
+	watchdog.stop()
+
+	if n := quiet.Load(); n != 1 {
+		t.Errorf("expected a single quiet report, got %d", n)
+	}
+}
@@ -0,0 +1,463 @@
+package kat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WorkloadKind is a kind of workload whose pods can be streamed.
+type WorkloadKind string
+
+const (
+	WorkloadDeployment  WorkloadKind = "Deployment"
+	WorkloadStatefulSet WorkloadKind = "StatefulSet"
+	WorkloadDaemonSet   WorkloadKind = "DaemonSet"
+	WorkloadJob         WorkloadKind = "Job"
+
+	// WorkloadService selects the pods backing a Service, which
+	// are matched by the Service's selector rather than by
+	// ownership. Changes to the selector are followed.
+	WorkloadService WorkloadKind = "Service"
+
+	// WorkloadOwner selects the pods whose chain of owner
+	// references reaches an object of any kind, such as a custom
+	// resource managed by an operator. Following the chain
+	// requires StreamConfig.Metadata.
+	WorkloadOwner WorkloadKind = "Owner"
+)
+
+// ownerPrefix introduces an owner target in ParseWorkload.
+const ownerPrefix = "owner="
+
+// workloadKinds maps the resource names and short names accepted by
+// ParseWorkload to workload kinds.
+var workloadKinds = map[string]WorkloadKind{
+	"deploy":       WorkloadDeployment,
+	"deployment":   WorkloadDeployment,
+	"deployments":  WorkloadDeployment,
+	"sts":          WorkloadStatefulSet,
+	"statefulset":  WorkloadStatefulSet,
+	"statefulsets": WorkloadStatefulSet,
+	"ds":           WorkloadDaemonSet,
+	"daemonset":    WorkloadDaemonSet,
+	"daemonsets":   WorkloadDaemonSet,
+	"job":          WorkloadJob,
+	"jobs":         WorkloadJob,
+	"svc":          WorkloadService,
+	"service":      WorkloadService,
+	"services":     WorkloadService,
+}
+
+// Workload identifies a workload, or a Service, whose pods are
+// streamed.
+type Workload struct {
+	Kind      WorkloadKind
+	Namespace string
+	Name      string
+	Owner     schema.GroupKind // Kind of the owning object, for WorkloadOwner.
+}
+
+// ParseWorkload parses a workload reference of the form kind/name,
+// such as deploy/checkout or statefulset/db, or an owner reference
+// of the form owner=group/kind/name, such as
+// owner=example.com/Database/orders, where the core group is written
+// as core. It reports false if the argument does not name a
+// workload. The namespace of the workload is left for the caller to
+// set.
+//
+// An argument whose first part is a kind is a workload unless its
+// name could not be an object name, because it holds a glob or a
+// container part. So in a namespace named like a kind, such as job,
+// job/worker-* and job/worker-0:* are namespace/pod patterns, while
+// job/worker-0 is the Job worker-0.
+func ParseWorkload(arg string) (Workload, bool, error) {
+	if owner, found := strings.CutPrefix(arg, ownerPrefix); found {
+		workload, err := parseOwner(owner)
+		if err != nil {
+			return Workload{}, true, fmt.Errorf("invalid owner %q: %w", arg, err)
+		}
+
+		return workload, true, nil
+	}
+
+	resource, name, found := strings.Cut(arg, "/")
+	if !found {
+		return Workload{}, false, nil
+	}
+
+	kind, ok := workloadKinds[strings.ToLower(resource)]
+	if !ok || strings.ContainsAny(name, "*?[]:") {
+		return Workload{}, false, nil
+	}
+
+	if name == "" || strings.Contains(name, "/") {
+		return Workload{}, true, fmt.Errorf("invalid workload %q: expected %s/<name>", arg, resource)
+	}
+
+	return Workload{Kind: kind, Name: name}, true, nil
+}
+
+func parseOwner(owner string) (Workload, error) {
+	parts := strings.Split(owner, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return Workload{}, fmt.Errorf("expected %sgroup/kind/name", ownerPrefix)
+	}
+
+	group := parts[0]
+	if group == "core" {
+		group = ""
+	}
+
+	return Workload{
+		Kind:  WorkloadOwner,
+		Name:  parts[2],
+		Owner: schema.GroupKind{Group: group, Kind: parts[1]},
+	}, nil
+}
+
+// String returns the workload in the form accepted by ParseWorkload.
+func (w Workload) String() string {
+	if w.Kind == WorkloadOwner {
+		group := w.Owner.Group
+		if group == "" {
+			group = "core"
+		}
+
+		return ownerPrefix + group + "/" + w.Owner.Kind + "/" + w.Name
+	}
+
+	return strings.ToLower(string(w.Kind)) + "/" + w.Name
+}
+
+// hasFixedSelector reports whether pods of the kind are selected by
+// an immutable label selector.
+func (k WorkloadKind) hasFixedSelector() bool {
+	switch k {
+	case WorkloadDeployment, WorkloadStatefulSet, WorkloadDaemonSet, WorkloadJob:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolvedWorkload is a workload looked up in the cluster. The UID
+// and selector of a Service are updated as the Service changes, under
+// the mutex of the workloadSet holding it.
+type resolvedWorkload struct {
+	Workload
+	uid      types.UID
+	selector labels.Selector
+}
+
+// resolveWorkload looks up a workload's UID and pod selector.
+func (k *Kat) resolveWorkload(ctx context.Context, workload Workload) (*resolvedWorkload, error) {
+	uid, selector, err := k.getWorkload(ctx, workload)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s in namespace %s: %w", workload, workload.Namespace, err)
+	}
+
+	return &resolvedWorkload{
+		Workload: workload,
+		uid:      uid,
+		selector: selector,
+	}, nil
+}
+
+func (k *Kat) getWorkload(ctx context.Context, workload Workload) (types.UID, labels.Selector, error) {
+	var (
+		uid      types.UID
+		selector *metav1.LabelSelector
+	)
+
+	switch workload.Kind {
+	case WorkloadDeployment:
+		deployment, err := k.clientset.AppsV1().Deployments(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+
+		uid, selector = deployment.UID, deployment.Spec.Selector
+	case WorkloadStatefulSet:
+		statefulSet, err := k.clientset.AppsV1().StatefulSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+
+		uid, selector = statefulSet.UID, statefulSet.Spec.Selector
+	case WorkloadDaemonSet:
+		daemonSet, err := k.clientset.AppsV1().DaemonSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+
+		uid, selector = daemonSet.UID, daemonSet.Spec.Selector
+	case WorkloadJob:
+		job, err := k.clientset.BatchV1().Jobs(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+
+		uid, selector = job.UID, job.Spec.Selector
+	case WorkloadService:
+		service, err := k.clientset.CoreV1().Services(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+
+		return service.UID, serviceSelector(service), nil
+	case WorkloadOwner:
+		if k.owners == nil {
+			return "", nil, fmt.Errorf("following owner references requires a metadata client")
+		}
+
+		uid, err := k.owners.resolve(ctx, workload.Namespace, workload.Owner, workload.Name)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return uid, labels.Everything(), nil
+	default:
+		return "", nil, fmt.Errorf("unsupported workload kind %q", workload.Kind)
+	}
+
+	if selector == nil {
+		return "", nil, fmt.Errorf("no pod selector")
+	}
+
+	podSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid pod selector: %w", err)
+	}
+
+	return uid, podSelector, nil
+}
+
+// serviceSelector returns the selector of the pods backing a
+// Service. A Service without a selector has its endpoints managed
+// by hand and selects no pods.
+func serviceSelector(service *corev1.Service) labels.Selector {
+	if len(service.Spec.Selector) == 0 {
+		return labels.Nothing()
+	}
+
+	return labels.SelectorFromSet(service.Spec.Selector)
+}
+
+// workloadSet holds the workloads streamed from a namespace and
+// decides which of the namespace's pods belong to them. Pods are
+// matched by following their controller references, so that pods
+// of other workloads whose labels happen to match are ignored and
+// pods from new ReplicaSets created by a rollout are picked up.
+// Pods backing a Service are matched by its current selector.
+type workloadSet struct {
+	k         *Kat
+	mu        sync.RWMutex // Guards the UIDs and selectors of Services.
+	workloads []*resolvedWorkload
+
+	// Controller UID of each ReplicaSet looked up, keyed by the
+	// ReplicaSet's UID.
+	replicaSetOwners sync.Map
+}
+
+// selectPods narrows pod list and watch requests to the workload's
+// pod selector when the set holds a single workload. Selectors of
+// several workloads cannot be combined into one request, so those
+// pods are filtered by ownership alone.
+func (s *workloadSet) selectPods(options *metav1.ListOptions) {
+	if s == nil || len(s.workloads) != 1 || !s.workloads[0].Kind.hasFixedSelector() {
+		return
+	}
+
+	requirements, _ := s.workloads[0].selector.Requirements()
+
+	// The configured selector was validated before streaming.
+	selector, _ := labels.Parse(options.LabelSelector)
+	options.LabelSelector = selector.Add(requirements...).String()
+}
+
+// includes reports whether the pod belongs to one of the workloads.
+// A nil set includes every pod. It returns an error if the pod's
+// owners could not be looked up, in which case whether the pod
+// belongs is not known.
+func (s *workloadSet) includes(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+
+	if s.backs(pod) {
+		return true, nil
+	}
+
+	owner := metav1.GetControllerOf(pod)
+	if owner != nil && s.owns(owner.UID) {
+		return true, nil
+	}
+
+	if owner != nil && owner.Kind == "ReplicaSet" {
+		replicaSetOwner, err := s.replicaSetOwner(ctx, pod.Namespace, owner)
+		if err != nil {
+			return false, fmt.Errorf("error resolving owner of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+
+		if s.owns(replicaSetOwner) {
+			return true, nil
+		}
+	}
+
+	if !s.hasKind(WorkloadOwner) || s.k.owners == nil {
+		return false, nil
+	}
+
+	reached, err := s.k.owners.reaches(ctx, pod.Namespace, pod.OwnerReferences, s.owns)
+	if err != nil {
+		return false, fmt.Errorf("error resolving owner of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return reached, nil
+}
+
+func (s *workloadSet) owns(uid types.UID) bool {
+	for _, workload := range s.workloads {
+		if workload.Kind != WorkloadService && workload.uid == uid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backs reports whether the pod backs one of the Services.
+func (s *workloadSet) backs(pod *corev1.Pod) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, workload := range s.workloads {
+		if workload.Kind == WorkloadService && workload.selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *workloadSet) hasKind(kind WorkloadKind) bool {
+	if s == nil {
+		return false
+	}
+
+	for _, workload := range s.workloads {
+		if workload.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateService records the current state of a Service, which is
+// nil if it has been deleted, and reports whether the pods it
+// selects may have changed.
+func (s *workloadSet) updateService(name string, service *corev1.Service) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid, selector := types.UID(""), labels.Nothing()
+	if service != nil {
+		uid, selector = service.UID, serviceSelector(service)
+	}
+
+	changed := false
+
+	for _, workload := range s.workloads {
+		if workload.Kind != WorkloadService || workload.Name != name {
+			continue
+		}
+
+		if workload.uid != uid || workload.selector.String() != selector.String() {
+			workload.uid, workload.selector = uid, selector
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// watchServices follows changes to the Services in the set, calling
+// reevaluate whenever the pods they select may have changed, until
+// ctx is done.
+func (s *workloadSet) watchServices(ctx context.Context, namespace string, reevaluate func()) error {
+	if !s.hasKind(WorkloadService) {
+		return nil
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(s.k.clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTransform(transformService),
+	)
+	serviceInformer := factory.Core().V1().Services().Informer()
+
+	update := func(obj any) {
+		if service, ok := obj.(*corev1.Service); ok && s.updateService(service.Name, service) {
+			reevaluate()
+		}
+	}
+
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, newObj any) {
+			update(newObj)
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			if service, ok := obj.(*corev1.Service); ok && s.updateService(service.Name, nil) {
+				reevaluate()
+			}
+		},
+	})
+
+	go serviceInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), serviceInformer.HasSynced) && ctx.Err() == nil {
+		return fmt.Errorf("failed to sync service informer cache for namespace %s", namespace)
+	}
+
+	return nil
+}
+
+// replicaSetOwner returns the UID of the controller of a ReplicaSet,
+// which is empty if the ReplicaSet has no controller.
+func (s *workloadSet) replicaSetOwner(ctx context.Context, namespace string, ref *metav1.OwnerReference) (types.UID, error) {
+	if owner, ok := s.replicaSetOwners.Load(ref.UID); ok {
+		return owner.(types.UID), nil
+	}
+
+	replicaSet, err := s.k.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	var owner types.UID
+
+	if replicaSet.UID == ref.UID {
+		if controller := metav1.GetControllerOf(replicaSet); controller != nil {
+			owner = controller.UID
+		}
+	}
+
+	s.replicaSetOwners.Store(ref.UID, owner)
+
+	return owner, nil
+}
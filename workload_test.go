@@ -0,0 +1,364 @@
+package kat
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseWorkload(t *testing.T) {
+	tests := []struct {
+		name      string
+		arg       string
+		expected  Workload
+		expectOK  bool
+		expectErr bool
+	}{
+		{
+			name:     "deployment short name",
+			arg:      "deploy/checkout",
+			expected: Workload{Kind: WorkloadDeployment, Name: "checkout"},
+			expectOK: true,
+		},
+		{
+			name:     "statefulset",
+			arg:      "StatefulSet/db",
+			expected: Workload{Kind: WorkloadStatefulSet, Name: "db"},
+			expectOK: true,
+		},
+		{
+			name:     "daemonset plural",
+			arg:      "daemonsets/node-agent",
+			expected: Workload{Kind: WorkloadDaemonSet, Name: "node-agent"},
+			expectOK: true,
+		},
+		{
+			name:     "job",
+			arg:      "job/migrate",
+			expected: Workload{Kind: WorkloadJob, Name: "migrate"},
+			expectOK: true,
+		},
+		{
+			name:     "service",
+			arg:      "svc/frontend",
+			expected: Workload{Kind: WorkloadService, Name: "frontend"},
+			expectOK: true,
+		},
+		{
+			name:     "owner",
+			arg:      "owner=example.com/Database/orders",
+			expected: Workload{Kind: WorkloadOwner, Name: "orders", Owner: schema.GroupKind{Group: "example.com", Kind: "Database"}},
+			expectOK: true,
+		},
+		{
+			name:     "owner in the core group",
+			arg:      "owner=core/ConfigMap/settings",
+			expected: Workload{Kind: WorkloadOwner, Name: "settings", Owner: schema.GroupKind{Kind: "ConfigMap"}},
+			expectOK: true,
+		},
+		{
+			name:      "owner without kind",
+			arg:       "owner=example.com/orders",
+			expectOK:  true,
+			expectErr: true,
+		},
+		{
+			name:     "namespace",
+			arg:      "shop",
+			expectOK: false,
+		},
+		{
+			name:     "namespace and pod",
+			arg:      "shop/web-0",
+			expectOK: false,
+		},
+		{
+			name:     "pod glob in a namespace named like a kind",
+			arg:      "job/worker-*",
+			expectOK: false,
+		},
+		{
+			name:     "pod and container in a namespace named like a kind",
+			arg:      "svc/proxy-0:*",
+			expectOK: false,
+		},
+		{
+			name:     "pod in a namespace named like a kind",
+			arg:      "job/worker-0",
+			expected: Workload{Kind: WorkloadJob, Name: "worker-0"},
+			expectOK: true,
+		},
+		{
+			name:      "missing name",
+			arg:       "deploy/",
+			expectOK:  true,
+			expectErr: true,
+		},
+		{
+			name:      "too many parts",
+			arg:       "deploy/checkout/app",
+			expectOK:  true,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload, ok, err := ParseWorkload(tt.arg)
+
+			if ok != tt.expectOK {
+				t.Errorf("expected ok=%v, got %v", tt.expectOK, ok)
+			}
+
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if workload != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, workload)
+			}
+		})
+	}
+}
+
+func newDeployment(namespace, name string, labels map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID("uid-" + name),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+		},
+	}
+}
+
+func newReplicaSet(owner *appsv1.Deployment, name string) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       owner.Namespace,
+			Name:            name,
+			UID:             types.UID("uid-" + name),
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+	}
+}
+
+// newOwnedPod returns a running pod controlled by owner.
+func newOwnedPod(owner metav1.Object, kind, name string, labels map[string]string) *corev1.Pod {
+	pod := newPod(owner.GetNamespace(), name, corev1.PodRunning, "app")
+	pod.Labels = labels
+	pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, appsv1.SchemeGroupVersion.WithKind(kind))}
+
+	return pod
+}
+
+func TestStartStreamingWorkloads(t *testing.T) {
+	labels := map[string]string{"app": "checkout"}
+
+	checkout := newDeployment("shop", "checkout", labels)
+	canary := newDeployment("shop", "checkout-canary", labels)
+	current := newReplicaSet(checkout, "checkout-abc")
+	canaryReplicaSet := newReplicaSet(canary, "checkout-canary-abc")
+
+	db := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "db", UID: "uid-db"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+	}
+
+	orphan := newPod("shop", "orphan", corev1.PodRunning, "app")
+	orphan.Labels = labels
+
+	clientset, watching := newClientset(
+		checkout, canary, current, canaryReplicaSet, db, orphan,
+		newOwnedPod(current, "ReplicaSet", "checkout-abc-1", labels),
+		newOwnedPod(canaryReplicaSet, "ReplicaSet", "checkout-canary-abc-1", labels),
+		newOwnedPod(db, "StatefulSet", "db-0", map[string]string{"app": "db"}),
+	)
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	workloads := []Workload{
+		{Kind: WorkloadDeployment, Namespace: "shop", Name: "checkout"},
+		{Kind: WorkloadStatefulSet, Namespace: "shop", Name: "db"},
+	}
+
+	go k.StartStreamingWorkloads(ctx, workloads, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+
+	// A rollout creates a new ReplicaSet, whose pods are picked up.
+	next := newReplicaSet(checkout, "checkout-def")
+	if _, err := clientset.AppsV1().ReplicaSets("shop").Create(ctx, next, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := clientset.CoreV1().Pods("shop").Create(ctx, newOwnedPod(next, "ReplicaSet", "checkout-def-1", labels), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, stream := range []string{"shop/checkout-abc-1:app", "shop/db-0:app", "shop/checkout-def-1:app"} {
+		waitFor(t, stream, func() bool { return rec.hasStop(stream) })
+	}
+
+	for _, stream := range []string{"shop/checkout-canary-abc-1:app", "shop/orphan:app"} {
+		if rec.hasStart(stream) {
+			t.Errorf("expected no stream for %s", stream)
+		}
+	}
+}
+
+func TestStartStreamingWorkloads_OwnerLookupRetried(t *testing.T) {
+	labels := map[string]string{"app": "checkout"}
+
+	checkout := newDeployment("shop", "checkout", labels)
+	current := newReplicaSet(checkout, "checkout-abc")
+
+	clientset, watching := newClientset(checkout, current)
+
+	// The first lookup of the ReplicaSet fails.
+	var failed atomic.Bool
+	clientset.PrependReactor("get", "replicasets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failed.CompareAndSwap(false, true) {
+			return true, nil, apierrors.NewServiceUnavailable("try again")
+		}
+
+		return false, nil, nil
+	})
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreamingWorkloads(ctx, []Workload{{Kind: WorkloadDeployment, Namespace: "shop", Name: "checkout"}}, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+
+	if _, err := clientset.CoreV1().Pods("shop").Create(ctx, newOwnedPod(current, "ReplicaSet", "checkout-abc-1", labels), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The pod is decided again, without another event for it.
+	waitFor(t, "shop/checkout-abc-1:app", func() bool { return rec.hasStop("shop/checkout-abc-1:app") })
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	lookups := 0
+	for _, err := range rec.errs {
+		if apierrors.IsServiceUnavailable(err) {
+			lookups++
+		}
+	}
+
+	if lookups != 1 {
+		t.Errorf("expected the failed lookup to be reported once, got %v", rec.errs)
+	}
+}
+
+func TestStartStreamingWorkloads_SinglePodSelector(t *testing.T) {
+	checkout := newDeployment("shop", "checkout", map[string]string{"app": "checkout"})
+	clientset, watching := newClientset(checkout)
+
+	k := New(clientset, &StreamConfig{Retry: noRetries(), LabelSelector: "tier=web"}, &OutputConfig{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreamingWorkloads(ctx, []Workload{{Kind: WorkloadDeployment, Namespace: "shop", Name: "checkout"}}, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+
+	for _, action := range clientset.Actions() {
+		if action, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "pods" {
+			if got := action.GetListRestrictions().Labels.String(); got != "app=checkout,tier=web" {
+				t.Errorf("expected the workload's pod selector to be combined with the label selector, got %q", got)
+			}
+		}
+	}
+}
+
+func TestStartStreamingWorkloads_NotFound(t *testing.T) {
+	clientset, _ := newClientset()
+	k := New(clientset, nil, &OutputConfig{}, nil)
+
+	err := k.StartStreamingWorkloads(context.Background(), []Workload{{Kind: WorkloadJob, Namespace: "shop", Name: "migrate"}}, time.Minute)
+	if err == nil {
+		t.Fatal("expected an error for a missing workload")
+	}
+
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "pods" {
+			t.Errorf("expected no pod requests, got %s", action.GetVerb())
+		}
+	}
+}
+
+func TestStartStreamingWorkloads_Service(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "frontend", UID: "uid-frontend"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+	}
+
+	web := newPod("shop", "web-0", corev1.PodRunning, "app")
+	web.Labels = map[string]string{"app": "web"}
+
+	api := newPod("shop", "api-0", corev1.PodRunning, "app")
+	api.Labels = map[string]string{"app": "api"}
+
+	clientset, watching := newClientset(service, web, api)
+	watchingServices := watchEstablished(clientset, "services")
+
+	rec := &recorder{}
+	k := New(clientset, &StreamConfig{Retry: noRetries()}, &OutputConfig{}, rec.callbacks())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.StartStreamingWorkloads(ctx, []Workload{{Kind: WorkloadService, Namespace: "shop", Name: "frontend"}}, time.Minute)
+
+	waitForChannel(t, "pod watch", watching)
+	waitFor(t, "web-0 stream", func() bool { return rec.hasStop("shop/web-0:app") })
+
+	if rec.hasStart("shop/api-0:app") {
+		t.Errorf("expected no stream for a pod outside the service selector")
+	}
+
+	waitForChannel(t, "service watch", watchingServices)
+
+	// The service is switched over to the api pods.
+	service = service.DeepCopy()
+	service.Spec.Selector = map[string]string{"app": "api"}
+	if _, err := clientset.CoreV1().Services("shop").Update(ctx, service, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitFor(t, "api-0 stream", func() bool { return rec.hasStop("shop/api-0:app") })
+	waitFor(t, "web-0 stream to be removed", func() bool { return !hasActiveStream(k, podStreamKey(web)) })
+}